@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/charmbracelet/log"
+)
+
+// accessLog writes one line per HTTP request in the Combined Log Format that
+// Apache and nginx use, so the usual log tools can read it
+type accessLog struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+// openAccessLog opens (or creates) the access log at path for appending. An
+// empty path turns the access log off.
+func openAccessLog(path string) (*accessLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create access log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+	return &accessLog{w: f}, nil
+}
+
+// Close closes the access log file
+func (l *accessLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.w.Close()
+}
+
+// statusRecorder remembers the status code and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Hijack lets WebSocket upgrades through, logging them as 101
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	conn, brw, err := hj.Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests wraps a handler so every request is written to the access log
+// and logged at debug level. WebSocket requests are logged once they close.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		user := "-"
+		if name, _, ok := r.BasicAuth(); ok && s.opts.Auth.enabled() && status != http.StatusUnauthorized {
+			user = name
+		}
+
+		logger.Debug("HTTP request", "method", r.Method, "path", r.URL.Path, "status", status, "bytes", rec.size, "duration", time.Since(start), "remote", r.RemoteAddr)
+
+		if s.access == nil {
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		line := fmt.Sprintf("%s - %s [%s] %q %d %d %q %q\n",
+			host,
+			user,
+			start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+redactedURI(r.URL)+" "+r.Proto,
+			status,
+			rec.size,
+			orDash(r.Referer()),
+			orDash(r.UserAgent()),
+		)
+
+		s.access.mu.Lock()
+		defer s.access.mu.Unlock()
+		io.WriteString(s.access.w, line)
+	})
+}
+
+// secretParams are query parameters that must never end up in a log
+var secretParams = []string{csrfQueryParam, "token", "watch"}
+
+// redactedURI returns the request URI with secrets like CSRF and share
+// tokens blanked out
+func redactedURI(u *url.URL) string {
+	query := u.Query()
+	redacted := false
+	for _, name := range secretParams {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.RequestURI()
+	}
+	out := *u
+	out.RawQuery = query.Encode()
+	return out.RequestURI()
+}
+
+// orDash returns "-" for empty log fields, as the Combined Log Format expects
+func orDash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
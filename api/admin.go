@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	logger "github.com/charmbracelet/log"
+	"github.com/coder/websocket"
+)
+
+// SessionInfo describes a live session for the admin pages
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	User       string    `json:"user"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Started    time.Time `json:"started"`
+	LastInput  time.Time `json:"lastInput"`
+	Profile    string    `json:"profile"`
+	Command    string    `json:"command"`
+	Viewers    int       `json:"viewers"`
+	BytesIn    int64     `json:"bytesIn"`
+	BytesOut   int64     `json:"bytesOut"`
+}
+
+// Info returns a snapshot of the session for the admin pages
+func (s *Session) Info() SessionInfo {
+	return SessionInfo{
+		ID:         s.ID,
+		User:       s.User,
+		RemoteAddr: s.RemoteAddr,
+		Started:    s.Started,
+		LastInput:  s.LastInput(),
+		Profile:    s.Profile,
+		Command:    s.Command,
+		Viewers:    s.ViewerCount(),
+		BytesIn:    s.bytesIn.Load(),
+		BytesOut:   s.bytesOut.Load(),
+	}
+}
+
+// handleHealthz reports that the process is alive
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether the server is taking new sessions
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	draining := s.draining
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if draining {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ready\n"))
+}
+
+// handleMetrics serves metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	sessions := s.sessions.list()
+	spectators := 0
+	for _, sess := range sessions {
+		spectators += sess.ViewerCount()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.write(w, len(sessions), spectators)
+}
+
+// adminData is what admin.html gets rendered with
+type adminData struct {
+	CSRFToken string
+	User      string
+	Sessions  []SessionInfo
+}
+
+// handleAdminSessions renders the admin page listing live sessions
+func (s *Server) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := s.assets.page("admin.html")
+	if err != nil {
+		logger.Error("Failed to load admin.html", "err", err)
+		http.Error(w, "failed to load page", http.StatusInternalServerError)
+		return
+	}
+
+	// Closing a session is a form post, so it needs a CSRF token
+	sessionID, err := s.csrf.ensureSession(w, r)
+	if err != nil {
+		logger.Error("Failed to create session cookie", "err", err)
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	data := adminData{
+		CSRFToken: s.csrf.token(sessionID),
+		User:      userFrom(r),
+		Sessions:  s.sessionInfos(),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, data); err != nil {
+		logger.Error("Failed to render admin.html", "err", err)
+	}
+}
+
+// handleAdminClosePost force-closes a session from the admin page's form and
+// goes back to the list
+func (s *Server) handleAdminClosePost(w http.ResponseWriter, r *http.Request) {
+	if err := s.csrf.validate(r); err != nil {
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	if !s.forceClose(r, r.PathValue("id")) {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+}
+
+// handleAdminListJSON lists live sessions as JSON
+func (s *Server) handleAdminListJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(s.sessionInfos())
+}
+
+// handleAdminCloseJSON force-closes a session through the JSON API. The
+// program gets its grace period to exit, so this answers 202 once the close
+// has started rather than waiting for it.
+func (s *Server) handleAdminCloseJSON(w http.ResponseWriter, r *http.Request) {
+	if !s.forceClose(r, r.PathValue("id")) {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sessionInfos snapshots every live session, oldest first
+func (s *Server) sessionInfos() []SessionInfo {
+	sessions := s.sessions.list()
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		infos = append(infos, sess.Info())
+	}
+	return infos
+}
+
+// forceClose starts ending a session on an admin's behalf. Everyone is
+// disconnected straight away; stopping the program carries on in the
+// background so the request doesn't wait out its grace period.
+func (s *Server) forceClose(r *http.Request, id string) bool {
+	sess := s.sessions.get(id)
+	if sess == nil {
+		return false
+	}
+	logger.Info("Admin closed session", "admin", userFrom(r), "session", id)
+	s.audit.record(AuditEvent{
+		Event:      auditAdminClose,
+		SessionID:  id,
+		RemoteAddr: r.RemoteAddr,
+		User:       userFrom(r),
+		UserAgent:  r.UserAgent(),
+	})
+	go sess.closeWithStatus(websocket.StatusPolicyViolation, "closed by an admin")
+	return true
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// slowTerminal is a fake terminal whose program takes until release is
+// closed to stop, like one using its whole grace period
+type slowTerminal struct {
+	*FakeTerminal
+	release chan struct{}
+}
+
+func (t *slowTerminal) Close() error {
+	<-t.release
+	return t.FakeTerminal.Close()
+}
+
+func TestAdminForceClose(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	fakes, started := FakeStarter()
+	s, ts, _ := newTestServer(t, Options{
+		Auth: AuthOptions{
+			Users:  map[string]string{"marcy": "admin-pw", "bob": "user-pw"},
+			Admins: []string{"marcy"},
+		},
+		StartTerminal: func(spec Spec) (Terminal, error) {
+			term, err := fakes(spec)
+			return &slowTerminal{term.(*FakeTerminal), release}, err
+		},
+	})
+
+	owner := dialAs(t, s, ts, "/ws", "bob", "user-pw")
+	f := <-started
+	t.Cleanup(func() { f.Exit(0) })
+
+	var id string
+	deadline := time.Now().Add(testTimeout)
+	for id == "" && time.Now().Before(deadline) {
+		if sessions := s.sessions.list(); len(sessions) > 0 {
+			id = sessions[0].ID
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	closeSession := func(user, password, id string) int {
+		t.Helper()
+		req, _ := http.NewRequest("DELETE", ts.URL+"/admin/api/sessions/"+id, nil)
+		req.SetBasicAuth(user, password)
+		client := ts.Client()
+		client.Timeout = testTimeout
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := closeSession("bob", "user-pw", id); code != http.StatusForbidden {
+		t.Errorf("non-admin close answered %d, want 403", code)
+	}
+	if code := closeSession("marcy", "admin-pw", "nope"); code != http.StatusNotFound {
+		t.Errorf("closing an unknown session answered %d, want 404", code)
+	}
+
+	// The program is still stopping, but the admin gets an answer and the
+	// owner is disconnected right away
+	if code := closeSession("marcy", "admin-pw", id); code != http.StatusAccepted {
+		t.Errorf("admin close answered %d, want 202", code)
+	}
+	code, reason := readClose(t, owner)
+	if code != websocket.StatusPolicyViolation || reason != "closed by an admin" {
+		t.Errorf("owner closed with %d %q, want %d %q", code, reason, websocket.StatusPolicyViolation, "closed by an admin")
+	}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/charmbracelet/log"
+)
+
+// Audit event names
+const (
+	auditSessionStart   = "session_start"
+	auditSessionEnd     = "session_end"
+	auditCommand        = "command"
+	auditSpectatorJoin  = "spectator_join"
+	auditSpectatorLeave = "spectator_leave"
+	auditAdminClose     = "admin_close"
+	auditAPICommand     = "api_command"
+	auditJobSubmit      = "job_submit"
+	auditJobCancel      = "job_cancel"
+	auditFileUpload     = "file_upload"
+	auditFileDownload   = "file_download"
+)
+
+// AuditEvent is one line of the audit log
+type AuditEvent struct {
+	Time       time.Time   `json:"time"`
+	Event      string      `json:"event"`
+	SessionID  string      `json:"sessionId,omitempty"`
+	JobID      string      `json:"jobId,omitempty"`
+	RemoteAddr string      `json:"remoteAddr,omitempty"`
+	User       string      `json:"user,omitempty"`
+	UserAgent  string      `json:"userAgent,omitempty"`
+	Profile    string      `json:"profile,omitempty"`
+	Command    string      `json:"command,omitempty"`
+	File       string      `json:"file,omitempty"`
+	Reason     string      `json:"reason,omitempty"`
+	Duration   float64     `json:"durationSeconds,omitempty"`
+	BytesIn    int64       `json:"bytesIn,omitempty"`
+	BytesOut   int64       `json:"bytesOut,omitempty"`
+	Exit       *ExitStatus `json:"exit,omitempty"`
+}
+
+// auditLog appends audit events to a file as JSON lines. A nil auditLog
+// drops everything, so callers don't have to check whether auditing is on.
+type auditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// openAuditLog opens (or creates) the audit log at path for appending. An
+// empty path turns auditing off.
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &auditLog{f: f}, nil
+}
+
+// record writes an event, stamping it with the current time
+func (a *auditLog) record(event AuditEvent) {
+	if a == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+
+	var line bytes.Buffer
+	if err := json.NewEncoder(&line).Encode(event); err != nil {
+		logger.Error("Failed to encode audit event", "err", err)
+		return
+	}
+
+	// One write per line so events never interleave
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(line.Bytes()); err != nil {
+		logger.Error("Failed to write audit event", "err", err)
+	}
+}
+
+// Close closes the audit log file
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.f.Close()
+}
+
+// SessionEnv is set in the environment of every web terminal program to its
+// session id, so marcli knows it's running inside cutiepie-tty
+const SessionEnv = "MARCLI_TTY_SESSION"
+
+// CommandFDEnv tells a program started with a Spec.CommandPipe which file
+// descriptor it is
+const CommandFDEnv = "MARCLI_TTY_COMMAND_FD"
+
+// maxCommandName bounds how long a reported command name may be
+const maxCommandName = 256
+
+var (
+	commandReporter     *os.File
+	commandReporterOnce sync.Once
+)
+
+// ReportCommand tells the cutiepie-tty server which menu command is about to
+// run, for its audit log. Outside the web terminal it does nothing.
+func ReportCommand(name string) {
+	commandReporterOnce.Do(func() {
+		fd, err := strconv.Atoi(os.Getenv(CommandFDEnv))
+		if err != nil || fd < 3 {
+			return
+		}
+		commandReporter = os.NewFile(uintptr(fd), "marcli-commands")
+		// Whatever the command starts doesn't get to report anything
+		closeOnExec(commandReporter)
+		os.Unsetenv(CommandFDEnv)
+	})
+	if commandReporter != nil {
+		fmt.Fprintln(commandReporter, name)
+	}
+}
+
+// readCommands records every command the program reports until it closes
+// the pipe
+func (s *Session) readCommands(r *os.File) {
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, maxCommandName), maxCommandName)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" {
+			continue
+		}
+		s.audit.record(AuditEvent{
+			Event:      auditCommand,
+			SessionID:  s.ID,
+			RemoteAddr: s.RemoteAddr,
+			User:       s.User,
+			Profile:    s.Profile,
+			Command:    name,
+		})
+	}
+}
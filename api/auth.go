@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"slices"
+)
+
+// AuthOptions configures HTTP basic auth for the web terminal
+type AuthOptions struct {
+	// Users maps usernames to passwords. With no users anyone who can reach
+	// the server gets a terminal, and the admin pages are turned off.
+	Users map[string]string `yaml:"users"`
+	// Admins are the users allowed on the /admin pages
+	Admins []string `yaml:"admins"`
+}
+
+// enabled reports whether logging in is required
+func (a AuthOptions) enabled() bool {
+	return len(a.Users) > 0
+}
+
+// check reports whether the credentials are right
+func (a AuthOptions) check(user, password string) bool {
+	want, ok := a.Users[user]
+	if !ok {
+		// Compare anyway so unknown users take as long as wrong passwords
+		want = "\x00"
+	}
+	// Hash both sides so the comparison doesn't leak the password length
+	got := sha256.Sum256([]byte(password))
+	expected := sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(got[:], expected[:]) == 1 && ok
+}
+
+// isAdmin reports whether a user may use the admin pages
+func (a AuthOptions) isAdmin(user string) bool {
+	return a.enabled() && slices.Contains(a.Admins, user)
+}
+
+type userContextKey struct{}
+
+// userFrom returns the logged in user for a request ("" with auth off)
+func userFrom(r *http.Request) string {
+	user, _ := r.Context().Value(userContextKey{}).(string)
+	return user
+}
+
+// requireUser asks for basic auth credentials when users are configured and
+// remembers who logged in for the rest of the request
+func (s *Server) requireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.opts.Auth.enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, password, ok := r.BasicAuth()
+		if !ok || !s.opts.Auth.check(user, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="marcli", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey{}, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireAdmin only lets admins through. It must run inside requireUser.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.opts.Auth.enabled() {
+			http.Error(w, "admin pages are off - configure web.auth to use them", http.StatusNotFound)
+			return
+		}
+		if !s.opts.Auth.isAdmin(userFrom(r)) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"marcli/media"
+
+	logger "github.com/charmbracelet/log"
+)
+
+// Command is a registered marcli command that the HTTP API may run. Only
+// non-interactive commands belong here - there's no terminal to answer a
+// picker.
+type Command struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Flags are the options the command accepts
+	Flags []Flag `json:"flags,omitempty"`
+	// Args says whether the command takes positional arguments
+	Args bool `json:"args,omitempty"`
+	// Actions, if set, are the only things the first argument may be, e.g.
+	// ["list", "export"] to keep the API away from an interactive "play"
+	Actions []string `json:"actions,omitempty"`
+	// Fixed are flags always passed ahead of the request's options, which
+	// the request can't change, e.g. ["--out", "-"] to send exports back in
+	// the response instead of to a file on the server
+	Fixed []string `json:"-"`
+	// Script marks a program defined in config.yml rather than a marcli command
+	Script bool `json:"script,omitempty"`
+}
+
+// Flag is an option a Command accepts, named without the leading "--"
+type Flag struct {
+	Name string `json:"name"`
+	// Value marks a flag that takes a value ("--flag value"). Other flags are
+	// switches, set with true and passed bare.
+	Value bool `json:"value,omitempty"`
+}
+
+// flag finds one of the command's flags by name
+func (c Command) flag(name string) (Flag, bool) {
+	for _, f := range c.Flags {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Flag{}, false
+}
+
+// CommandRequest is the JSON body of POST /api/commands/{name}
+type CommandRequest struct {
+	// Options are flag values, e.g. {"fast": true} or {"out": "x.mov"}
+	Options map[string]any `json:"options"`
+	// Args are positional arguments
+	Args []string `json:"args"`
+}
+
+// CommandResult is how a command run ended
+type CommandResult struct {
+	Command  string  `json:"command"`
+	Output   string  `json:"output,omitempty"`
+	ExitCode int     `json:"exitCode"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"durationSeconds"`
+}
+
+// commands returns every command the API may run: registered marcli
+// commands plus scripts from config.yml, sorted by name
+func (o Options) commands() []Command {
+	all := append([]Command(nil), o.Commands...)
+	for _, script := range o.Scripts {
+		all = append(all, Command{
+			Name:        script.Name,
+			Description: script.Description,
+			Args:        true,
+			Script:      true,
+		})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name < all[j].Name
+	})
+	return all
+}
+
+// command finds a command the API may run by name
+func (o Options) command(name string) (Command, bool) {
+	for _, c := range o.commands() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// commandSpec works out the program to run for a command request
+func (o Options) commandSpec(name string, req CommandRequest) (Spec, error) {
+	command, ok := o.command(name)
+	if !ok {
+		return Spec{}, fmt.Errorf("unknown command %q", name)
+	}
+	if len(req.Args) > 0 && !command.Args {
+		return Spec{}, fmt.Errorf("command %q takes no arguments", name)
+	}
+	for _, arg := range req.Args {
+		// Flags have to go through Options so they get checked
+		if strings.HasPrefix(arg, "-") && !command.Script {
+			return Spec{}, fmt.Errorf("argument %q looks like a flag - pass it in options", arg)
+		}
+	}
+	if len(command.Actions) > 0 && len(req.Args) > 0 && !slices.Contains(command.Actions, req.Args[0]) {
+		return Spec{}, fmt.Errorf("command %q can only %s through the API", name, strings.Join(command.Actions, " or "))
+	}
+
+	flags := append([]string(nil), command.Fixed...)
+	keys := make([]string, 0, len(req.Options))
+	for k := range req.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		flag, ok := command.flag(k)
+		if !ok {
+			return Spec{}, fmt.Errorf("command %q has no option %q", name, k)
+		}
+		if !flag.Value {
+			on, ok := req.Options[k].(bool)
+			if !ok {
+				return Spec{}, fmt.Errorf("option %q is a switch - it must be true or false", k)
+			}
+			if on {
+				flags = append(flags, "--"+k)
+			}
+			continue
+		}
+
+		var value string
+		switch v := req.Options[k].(type) {
+		case string:
+			value = v
+		case float64:
+			value = fmt.Sprint(v)
+		default:
+			return Spec{}, fmt.Errorf("option %q must be a string or number", k)
+		}
+		// A value like "--out" would be read as a flag of its own
+		if strings.HasPrefix(value, "-") {
+			return Spec{}, fmt.Errorf("option %q can't start with \"-\"", k)
+		}
+		flags = append(flags, "--"+k, value)
+	}
+
+	// Scripts run whatever config.yml says, with the args tacked on
+	if command.Script {
+		for _, script := range o.Scripts {
+			if script.Name == name {
+				spec, err := script.Spec()
+				if err != nil {
+					return Spec{}, err
+				}
+				spec.Args = append(append(spec.Args, flags...), req.Args...)
+				spec.GracePeriod = o.KillGracePeriod
+				return spec, nil
+			}
+		}
+	}
+
+	// Marcli commands run in a fresh marcli process, so their output can be
+	// captured and they can't trample on the server
+	self, err := os.Executable()
+	if err != nil {
+		return Spec{}, err
+	}
+	args := append([]string{name}, flags...)
+	return Spec{
+		Path:        self,
+		Args:        append(args, req.Args...),
+		GracePeriod: o.KillGracePeriod,
+	}, nil
+}
+
+// runCommand runs spec without a terminal, calling onLine for every line of
+// output (stdout and stderr together). Cancelling ctx interrupts the program
+// and kills it if it hasn't exited after the spec's grace period.
+func runCommand(ctx context.Context, spec Spec, onLine func(string)) (int, error) {
+	c := exec.CommandContext(ctx, spec.Path, spec.Args...)
+	c.Dir = spec.Dir
+	c.Env = append(os.Environ(), spec.Env...)
+	c.Cancel = func() error {
+		// Ask nicely first, like Ctrl+C - Windows can't, so it's killed
+		if err := c.Process.Signal(os.Interrupt); err != nil {
+			return c.Process.Kill()
+		}
+		return nil
+	}
+	c.WaitDelay = spec.GracePeriod
+	if c.WaitDelay <= 0 {
+		c.WaitDelay = defaultGracePeriod
+	}
+
+	pr, pw := io.Pipe()
+	c.Stdout = pw
+	c.Stderr = pw
+
+	scanned := make(chan struct{})
+	go func() {
+		defer close(scanned)
+		scanner := bufio.NewScanner(pr)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			onLine(scanner.Text())
+		}
+		// Keep draining if a line was too long so the program never blocks
+		io.Copy(io.Discard, pr)
+	}()
+
+	err := c.Run()
+	pw.Close()
+	<-scanned
+
+	code := -1
+	if c.ProcessState != nil {
+		code = c.ProcessState.ExitCode()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// A non-zero exit is reported through the exit code
+		err = nil
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}
+	return code, err
+}
+
+// handleListCommands lists the commands the API may run
+func (s *Server) handleListCommands(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.opts.commands())
+}
+
+// handleRunCommand runs a command and returns its result as JSON, or streams
+// its output as Server-Sent Events if the client asks for text/event-stream
+func (s *Server) handleRunCommand(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var req CommandRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := r.PathValue("name")
+	spec, err := s.opts.commandSpec(name, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.Info("Running command from the API", "command", name, "args", spec.Args, "user", userFrom(r), "remote", r.RemoteAddr)
+	s.audit.record(AuditEvent{
+		Event:      auditAPICommand,
+		RemoteAddr: r.RemoteAddr,
+		User:       userFrom(r),
+		UserAgent:  r.UserAgent(),
+		Command:    spec.String(),
+	})
+
+	// Commands can take far longer than the HTTP write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.streamCommand(w, rc, r, name, spec)
+		return
+	}
+
+	// Plain JSON: collect the output and answer once it's done
+	start := time.Now()
+	var output strings.Builder
+	code, err := runCommand(r.Context(), spec, func(line string) {
+		output.WriteString(line)
+		output.WriteByte('\n')
+	})
+	result := CommandResult{
+		Command:  name,
+		Output:   output.String(),
+		ExitCode: code,
+		Duration: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil || code != 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// streamCommand runs a command, sending each line of output as an "output"
+// event, ffmpeg progress lines again as "progress" events with the parsed
+// progress, and the result as a final "exit" event
+func (s *Server) streamCommand(w http.ResponseWriter, rc *http.ResponseController, r *http.Request, name string, spec Spec) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	var mu sync.Mutex
+	send := func(event string, data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		writeEvent(w, event, data)
+		rc.Flush()
+	}
+
+	start := time.Now()
+	code, err := runCommand(r.Context(), spec, func(line string) {
+		data, _ := json.Marshal(line)
+		send("output", data)
+		if p, ok := media.ParseProgressLine(line); ok {
+			data, _ := json.Marshal(p)
+			send("progress", data)
+		}
+	})
+
+	result := CommandResult{
+		Command:  name,
+		ExitCode: code,
+		Duration: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	data, _ := json.Marshal(result)
+	send("exit", data)
+}
+
+// requireJSON refuses requests that aren't JSON. Browsers can't send JSON
+// cross-site without a CORS preflight, so this keeps other sites from
+// triggering commands the way a plain form post could.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "expected a JSON body", http.StatusUnsupportedMediaType)
+		return false
+	}
+	return true
+}
+
+// writeEvent writes one Server-Sent Event
+func writeEvent(w io.Writer, event string, data []byte) {
+	fmt.Fprintf(w, "event: %s\n", event)
+	for _, line := range strings.Split(string(data), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCommandSpec(t *testing.T) {
+	opts := Options{Commands: []Command{
+		{Name: "build", Flags: []Flag{{Name: "fast"}}},
+		{Name: "mega-combine", Flags: []Flag{{Name: "all"}, {Name: "force"}, {Name: "fps", Value: true}, {Name: "glob", Value: true}}, Args: true},
+		{Name: "recordings", Flags: []Flag{{Name: "format", Value: true}}, Args: true, Actions: []string{"list", "export"}, Fixed: []string{"--out", "-"}},
+	}}
+
+	tests := []struct {
+		name    string
+		command string
+		req     CommandRequest
+		args    []string // After the command name
+		err     string
+	}{
+		{
+			name:    "bare flag",
+			command: "build",
+			req:     CommandRequest{Options: map[string]any{"fast": true}},
+			args:    []string{"--fast"},
+		},
+		{
+			name:    "export goes to stdout",
+			command: "recordings",
+			req:     CommandRequest{Options: map[string]any{"format": "txt"}, Args: []string{"export", "demo"}},
+			args:    []string{"--out", "-", "--format", "txt", "export", "demo"},
+		},
+		{
+			name:    "no action lists",
+			command: "recordings",
+			args:    []string{"--out", "-"},
+		},
+		{
+			name:    "action not allowed",
+			command: "recordings",
+			req:     CommandRequest{Args: []string{"play", "demo"}},
+			err:     "can only list or export",
+		},
+		{
+			name:    "fixed flag can't be overridden",
+			command: "recordings",
+			req:     CommandRequest{Options: map[string]any{"out": "/etc/passwd"}, Args: []string{"export", "demo"}},
+			err:     `has no option "out"`,
+		},
+		{
+			name:    "flag smuggled in args",
+			command: "recordings",
+			req:     CommandRequest{Args: []string{"export", "--out=/tmp/x"}},
+			err:     "looks like a flag",
+		},
+		{
+			name:    "switch off",
+			command: "mega-combine",
+			req:     CommandRequest{Options: map[string]any{"all": true, "force": false}},
+			args:    []string{"--all"},
+		},
+		{
+			name:    "number value",
+			command: "mega-combine",
+			req:     CommandRequest{Options: map[string]any{"fps": 29.97, "glob": "*.mp4"}},
+			args:    []string{"--fps", "29.97", "--glob", "*.mp4"},
+		},
+		{
+			name:    "flag smuggled in a switch",
+			command: "mega-combine",
+			req:     CommandRequest{Options: map[string]any{"all": "--out", "force": true}, Args: []string{"/etc/cron.d/x.mkv"}},
+			err:     `option "all" is a switch`,
+		},
+		{
+			name:    "flag smuggled in a value",
+			command: "mega-combine",
+			req:     CommandRequest{Options: map[string]any{"glob": "--out"}, Args: []string{"/etc/cron.d/x.mkv"}},
+			err:     `option "glob" can't start with "-"`,
+		},
+		{
+			name:    "switch for a value",
+			command: "recordings",
+			req:     CommandRequest{Options: map[string]any{"format": true}},
+			err:     "must be a string or number",
+		},
+		{
+			name:    "args not taken",
+			command: "build",
+			req:     CommandRequest{Args: []string{"x"}},
+			err:     "takes no arguments",
+		},
+		{
+			name:    "unknown command",
+			command: "rm",
+			err:     "unknown command",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := opts.commandSpec(tt.command, tt.req)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := append([]string{tt.command}, tt.args...)
+			if !slices.Equal(spec.Args, want) {
+				t.Errorf("args = %q, want %q", spec.Args, want)
+			}
+		})
+	}
+}
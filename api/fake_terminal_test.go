@@ -0,0 +1,172 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// FakeTerminal is an in-memory Terminal backed by pipes, for testing the
+// WebSocket bridge, sessions and the resize protocol without real processes.
+// The test plays the part of the program: Emit writes output the session will
+// read, Input reads what the session typed, and Exit ends the program.
+type FakeTerminal struct {
+	// Spec is what the terminal was started with, if it came from
+	// FakeStarter. The test can report commands through its CommandPipe.
+	Spec Spec
+
+	outR *io.PipeReader
+	outW *io.PipeWriter
+	inR  *io.PipeReader
+	inW  *io.PipeWriter
+
+	mu       sync.Mutex
+	cols     uint16
+	rows     uint16
+	resizes  [][2]uint16
+	exitCode int
+	signal   string
+	exited   chan struct{}
+	once     sync.Once
+}
+
+// NewFakeTerminal creates a running fake terminal
+func NewFakeTerminal() *FakeTerminal {
+	outR, outW := io.Pipe()
+	inR, inW := io.Pipe()
+	return &FakeTerminal{
+		outR:     outR,
+		outW:     outW,
+		inR:      inR,
+		inW:      inW,
+		exitCode: -1,
+		exited:   make(chan struct{}),
+	}
+}
+
+// FakeStarter returns a TerminalStarter that creates fake terminals and
+// hands each one to the test through the returned channel
+func FakeStarter() (TerminalStarter, <-chan *FakeTerminal) {
+	started := make(chan *FakeTerminal, 16)
+	return func(spec Spec) (Terminal, error) {
+		f := NewFakeTerminal()
+		f.Spec = spec
+		started <- f
+		return f, nil
+	}, started
+}
+
+// Read returns output the fake program emitted
+func (f *FakeTerminal) Read(p []byte) (int, error) {
+	return f.outR.Read(p)
+}
+
+// Write sends input to the fake program. Like a real pipe it blocks until
+// the test reads it back with Input.
+func (f *FakeTerminal) Write(p []byte) (int, error) {
+	select {
+	case <-f.exited:
+		return 0, io.EOF
+	default:
+	}
+	return f.inW.Write(p)
+}
+
+// Resize records the new size
+func (f *FakeTerminal) Resize(cols, rows uint16) error {
+	select {
+	case <-f.exited:
+		return io.EOF
+	default:
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cols, f.rows = cols, rows
+	f.resizes = append(f.resizes, [2]uint16{cols, rows})
+	return nil
+}
+
+// Close ends the fake program as if it were killed
+func (f *FakeTerminal) Close() error {
+	f.exit(-1, "SIGKILL")
+	return nil
+}
+
+// Wait blocks until the fake program exits
+func (f *FakeTerminal) Wait() error {
+	<-f.exited
+	if code := f.ExitCode(); code != 0 {
+		return fmt.Errorf("exit status %d", code)
+	}
+	return nil
+}
+
+// ExitCode returns the exit code, or -1 while running
+func (f *FakeTerminal) ExitCode() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.exitCode
+}
+
+// ExitSignal returns the signal the fake program was killed with, if any
+func (f *FakeTerminal) ExitSignal() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.signal
+}
+
+// Emit writes output as the program, blocking until the session reads it
+func (f *FakeTerminal) Emit(data []byte) error {
+	_, err := f.outW.Write(data)
+	return err
+}
+
+// Input returns a reader for everything the session wrote to the program
+func (f *FakeTerminal) Input() io.Reader {
+	return f.inR
+}
+
+// Size returns the current terminal size
+func (f *FakeTerminal) Size() (cols, rows uint16) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cols, f.rows
+}
+
+// Resizes returns every size the terminal was resized to, in order
+func (f *FakeTerminal) Resizes() [][2]uint16 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][2]uint16(nil), f.resizes...)
+}
+
+// Exit ends the fake program with the given exit code
+func (f *FakeTerminal) Exit(code int) {
+	f.exit(code, "")
+}
+
+// Kill ends the fake program as if a signal (like "SIGTERM") killed it
+func (f *FakeTerminal) Kill(signal string) {
+	f.exit(-1, signal)
+}
+
+// Exited is closed once the program has exited
+func (f *FakeTerminal) Exited() <-chan struct{} {
+	return f.exited
+}
+
+func (f *FakeTerminal) exit(code int, signal string) {
+	f.once.Do(func() {
+		f.mu.Lock()
+		f.exitCode = code
+		f.signal = signal
+		f.mu.Unlock()
+		close(f.exited)
+		f.outW.Close()
+		f.inW.Close()
+		if f.Spec.CommandPipe != nil {
+			f.Spec.CommandPipe.Close()
+		}
+	})
+}
@@ -0,0 +1,679 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/charmbracelet/log"
+)
+
+// Upload settings
+const (
+	// defaultMediaQuota is how much the media dir may hold when no quota is set
+	defaultMediaQuota = 10 << 30
+	// maxChunkSize bounds a single PATCH, so one request can't hog the server
+	maxChunkSize = 64 << 20
+	// chunkTimeout is how long a chunk may take to arrive - slow links and
+	// big chunks need far longer than the usual read timeout
+	chunkTimeout = 10 * time.Minute
+	// uploadExpiry is how long an unfinished upload is kept without progress
+	uploadExpiry = 24 * time.Hour
+	// uploadsDir keeps unfinished uploads inside the media dir, hidden from
+	// the file browser
+	uploadsDir = ".uploads"
+)
+
+// ByteSize is a size in bytes that config.yml may spell like "20GB"
+type ByteSize int64
+
+// byteUnits are the suffixes ByteSize understands, biggest first
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// UnmarshalText parses sizes like "500MB", "20GB" or plain byte counts
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.ToUpper(strings.TrimSpace(string(text)))
+	mult := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			mult = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", text)
+	}
+	*b = ByteSize(n * float64(mult))
+	return nil
+}
+
+// String formats the size with the biggest unit that fits
+func (b ByteSize) String() string {
+	for _, unit := range byteUnits {
+		if int64(b) >= unit.size {
+			return strconv.FormatFloat(float64(b)/float64(unit.size), 'f', -1, 64) + unit.suffix
+		}
+	}
+	return "0B"
+}
+
+// FileInfo describes a file or directory in the media dir
+type FileInfo struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Dir     bool      `json:"dir"`
+}
+
+// FileListing is the JSON answer of GET /api/files
+type FileListing struct {
+	Dir     string     `json:"dir"`
+	Entries []FileInfo `json:"entries"`
+	Used    int64      `json:"used"`
+	Quota   int64      `json:"quota"`
+}
+
+// Upload is a resumable upload in progress. Chunks are appended to a part
+// file until it reaches Size, then it's moved to Path.
+type Upload struct {
+	ID      string    `json:"id"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	User    string    `json:"user,omitempty"`
+	Created time.Time `json:"created"`
+	// Offset is how many bytes have arrived (from the part file, not saved)
+	Offset int64 `json:"offset"`
+	// Done is set once the upload is complete and Path is where it ended up
+	Done bool `json:"done,omitempty"`
+}
+
+// uploadRequest is the JSON body of POST /api/uploads
+type uploadRequest struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// errQuotaExceeded is returned when an upload wouldn't fit in the quota
+var errQuotaExceeded = errors.New("not enough space left in the media quota")
+
+// mediaStore manages the media dir. Every file operation goes through an
+// os.Root, so even a symlink inside the dir can't lead outside it.
+type mediaStore struct {
+	root  *os.Root
+	quota int64
+
+	mu sync.Mutex
+	// busy holds the uploads with a chunk being written right now
+	busy map[string]bool
+}
+
+// newMediaStore opens the media dir, creating it if needed. An empty dir
+// turns file transfer off.
+func newMediaStore(dir string, quota ByteSize) (*mediaStore, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if quota <= 0 {
+		quota = defaultMediaQuota
+	}
+	if err := os.MkdirAll(filepath.Join(dir, uploadsDir), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create media directory: %w", err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open media directory: %w", err)
+	}
+	m := &mediaStore{root: root, quota: int64(quota), busy: make(map[string]bool)}
+	m.expireUploads()
+	return m, nil
+}
+
+// Close closes the media dir
+func (m *mediaStore) Close() error {
+	if m == nil {
+		return nil
+	}
+	return m.root.Close()
+}
+
+// cleanPath checks a slash-separated path from a request and returns it
+// relative to the media dir. Absolute paths, ".." and hidden names are
+// refused so nothing outside the media dir (or the uploads in progress)
+// can be reached.
+func cleanPath(p string) (string, error) {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ".", nil
+	}
+	if strings.ContainsAny(p, "\\\x00") || filepath.IsAbs(p) || filepath.VolumeName(p) != "" {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if elem == "" || strings.HasPrefix(elem, ".") {
+			return "", fmt.Errorf("invalid path %q", p)
+		}
+	}
+	return filepath.FromSlash(path.Clean(p)), nil
+}
+
+// list returns the entries of a directory, directories first
+func (m *mediaStore) list(dir string) ([]FileInfo, error) {
+	f, err := m.root.Open(dir)
+	if err != nil {
+		// Symlinks leading out of the media dir look like they aren't there
+		return nil, fs.ErrNotExist
+	}
+	defer f.Close()
+	entries, err := f.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		// Stat through the root so symlinks leading outside are left out
+		name := filepath.Join(dir, entry.Name())
+		info, err := m.root.Stat(name)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, FileInfo{
+			Name:    entry.Name(),
+			Path:    filepath.ToSlash(name),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Dir:     info.IsDir(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Dir != infos[j].Dir {
+			return infos[i].Dir
+		}
+		return strings.ToLower(infos[i].Name) < strings.ToLower(infos[j].Name)
+	})
+	return infos, nil
+}
+
+// used adds up everything in the media dir, unfinished uploads included
+func (m *mediaStore) used() int64 {
+	var total int64
+	fs.WalkDir(m.root.FS(), ".", func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// reserved is how many bytes unfinished uploads still have to send
+func (m *mediaStore) reserved() int64 {
+	var total int64
+	for _, u := range m.uploads() {
+		total += u.Size - u.Offset
+	}
+	return total
+}
+
+// uploads returns every unfinished upload
+func (m *mediaStore) uploads() []Upload {
+	paths, _ := fs.Glob(m.root.FS(), uploadsDir+"/*.json")
+	var uploads []Upload
+	for _, p := range paths {
+		if u, err := m.upload(strings.TrimSuffix(path.Base(p), ".json")); err == nil {
+			uploads = append(uploads, u)
+		}
+	}
+	return uploads
+}
+
+// upload loads an unfinished upload, with the offset it has reached
+func (m *mediaStore) upload(id string) (Upload, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return Upload{}, fs.ErrNotExist
+	}
+	data, err := m.root.ReadFile(m.metaPath(id))
+	if err != nil {
+		return Upload{}, err
+	}
+	var u Upload
+	if err := json.Unmarshal(data, &u); err != nil {
+		return Upload{}, err
+	}
+	info, err := m.root.Stat(m.partPath(id))
+	if err != nil {
+		return Upload{}, err
+	}
+	u.Offset = info.Size()
+	return u, nil
+}
+
+func (m *mediaStore) metaPath(id string) string {
+	return filepath.Join(uploadsDir, id+".json")
+}
+
+func (m *mediaStore) partPath(id string) string {
+	return filepath.Join(uploadsDir, id+".part")
+}
+
+// create starts an upload of size bytes to p, if it fits in the quota
+func (m *mediaStore) create(p string, size int64, user string) (Upload, error) {
+	if size < 0 {
+		return Upload{}, errors.New("size must not be negative")
+	}
+	dest, err := cleanPath(p)
+	if err != nil || dest == "." {
+		return Upload{}, fmt.Errorf("invalid path %q", p)
+	}
+	// The folder it goes in has to be a real folder inside the media dir
+	if dir := filepath.Dir(dest); dir != "." {
+		info, err := m.root.Stat(dir)
+		if err == nil && !info.IsDir() || err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return Upload{}, fmt.Errorf("invalid path %q", p)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireUploads()
+	if m.used()+m.reserved()+size > m.quota {
+		return Upload{}, errQuotaExceeded
+	}
+
+	id, err := randomID(16)
+	if err != nil {
+		return Upload{}, err
+	}
+	u := Upload{
+		ID:      id,
+		Path:    filepath.ToSlash(dest),
+		Size:    size,
+		User:    user,
+		Created: time.Now(),
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return Upload{}, err
+	}
+	if err := m.root.WriteFile(m.partPath(id), nil, 0600); err != nil {
+		return Upload{}, err
+	}
+	if err := m.root.WriteFile(m.metaPath(id), data, 0600); err != nil {
+		m.root.Remove(m.partPath(id))
+		return Upload{}, err
+	}
+
+	// Empty files have nothing to send, so they're done already
+	if size == 0 {
+		return m.finish(u)
+	}
+	return u, nil
+}
+
+// write appends a chunk at offset. A chunk cut short by a dropped
+// connection keeps what arrived, so the client can resume from there.
+func (m *mediaStore) write(id string, offset int64, body io.Reader) (Upload, error) {
+	m.mu.Lock()
+	if m.busy[id] {
+		m.mu.Unlock()
+		return Upload{}, errUploadBusy
+	}
+	m.busy[id] = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.busy, id)
+		m.mu.Unlock()
+	}()
+
+	u, err := m.upload(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	if offset != u.Offset {
+		return u, errOffsetMismatch
+	}
+
+	f, err := m.root.OpenFile(m.partPath(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return u, err
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(body, u.Size-u.Offset))
+	u.Offset += n
+	// Anything past the declared size would break the quota, so the whole
+	// chunk is refused
+	if copyErr == nil && u.Offset == u.Size {
+		if extra, _ := body.Read(make([]byte, 1)); extra > 0 {
+			copyErr = errTooMuchData
+			u.Offset = offset
+			f.Truncate(offset)
+		}
+	}
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		return u, copyErr
+	}
+
+	if u.Offset == u.Size {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.finish(u)
+	}
+	return u, nil
+}
+
+// Upload errors the handlers turn into status codes
+var (
+	errUploadBusy     = errors.New("another chunk of this upload is being written")
+	errOffsetMismatch = errors.New("Upload-Offset doesn't match the upload")
+	errTooMuchData    = errors.New("chunk goes past the upload's size")
+)
+
+// finish moves a complete upload into place. If the name is taken, the file
+// gets a " (1)", " (2)"... suffix rather than overwriting anything.
+func (m *mediaStore) finish(u Upload) (Upload, error) {
+	dest := filepath.FromSlash(u.Path)
+	if dir := filepath.Dir(dest); dir != "." {
+		if err := m.root.MkdirAll(dir, 0755); err != nil {
+			return u, err
+		}
+	}
+
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+	for i := 1; ; i++ {
+		if _, err := m.root.Lstat(dest); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if i > 1000 {
+			return u, fmt.Errorf("too many files named %s", u.Path)
+		}
+		dest = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+
+	if err := m.root.Rename(m.partPath(u.ID), dest); err != nil {
+		return u, err
+	}
+	m.root.Remove(m.metaPath(u.ID))
+	u.Path = filepath.ToSlash(dest)
+	u.Done = true
+	return u, nil
+}
+
+// abort throws an unfinished upload away. An upload with a chunk being
+// written is left alone, like write does.
+func (m *mediaStore) abort(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.busy[id] {
+		return errUploadBusy
+	}
+	if _, err := m.upload(id); err != nil {
+		return err
+	}
+	m.root.Remove(m.partPath(id))
+	return m.root.Remove(m.metaPath(id))
+}
+
+// expireUploads throws away uploads that haven't made progress in a while
+func (m *mediaStore) expireUploads() {
+	for _, u := range m.uploads() {
+		info, err := m.root.Stat(m.partPath(u.ID))
+		if err == nil && time.Since(info.ModTime()) > uploadExpiry && !m.busy[u.ID] {
+			logger.Info("Removing stale upload", "upload", u.ID, "path", u.Path)
+			m.root.Remove(m.partPath(u.ID))
+			m.root.Remove(m.metaPath(u.ID))
+		}
+	}
+}
+
+// media returns the media store, or answers 404 if file transfer is off
+func (s *Server) media(w http.ResponseWriter) (*mediaStore, bool) {
+	if s.files == nil {
+		http.Error(w, "file transfer is off - set web.mediaDir to use it", http.StatusNotFound)
+		return nil, false
+	}
+	return s.files, true
+}
+
+// handleListFiles lists a directory of the media dir
+func (s *Server) handleListFiles(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.media(w)
+	if !ok {
+		return
+	}
+	dir, err := cleanPath(r.URL.Query().Get("dir"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := m.list(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "directory not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to list media directory", "dir", dir, "err", err)
+		http.Error(w, "failed to list directory", http.StatusInternalServerError)
+		return
+	}
+
+	listing := FileListing{
+		Dir:     filepath.ToSlash(dir),
+		Entries: entries,
+		Used:    m.used(),
+		Quota:   m.quota,
+	}
+	if listing.Dir == "." {
+		listing.Dir = ""
+	}
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// handleDownload sends a file from the media dir, with range support so
+// big videos can be resumed or streamed
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.media(w)
+	if !ok {
+		return
+	}
+	name, err := cleanPath(r.PathValue("path"))
+	if err != nil || name == "." {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	f, err := m.root.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	logger.Info("File downloaded", "path", name, "user", userFrom(r), "remote", r.RemoteAddr)
+	s.audit.record(AuditEvent{
+		Event:      auditFileDownload,
+		RemoteAddr: r.RemoteAddr,
+		User:       userFrom(r),
+		UserAgent:  r.UserAgent(),
+		File:       filepath.ToSlash(name),
+	})
+
+	// Big files take far longer than the HTTP write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// handleCreateUpload starts a resumable upload
+func (s *Server) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.media(w)
+	if !ok {
+		return
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+	var req uploadRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u, err := m.create(req.Path, req.Size, userFrom(r))
+	if errors.Is(err, errQuotaExceeded) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.Info("Upload started", "upload", u.ID, "path", u.Path, "size", u.Size, "user", u.User)
+	if u.Done {
+		s.auditUpload(r, u)
+	}
+	w.Header().Set("Location", "/api/uploads/"+u.ID)
+	writeJSON(w, http.StatusCreated, u)
+}
+
+// handleGetUpload reports how far an upload got, so it can be resumed
+func (s *Server) handleGetUpload(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.media(w)
+	if !ok {
+		return
+	}
+	u, ok := s.ownUpload(w, r, m)
+	if !ok {
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	writeJSON(w, http.StatusOK, u)
+}
+
+// handleUploadChunk appends a chunk to an upload. The Upload-Offset header
+// must say where the chunk goes, so a retried chunk can't be written twice.
+func (s *Server) handleUploadChunk(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.media(w)
+	if !ok {
+		return
+	}
+	u, ok := s.ownUpload(w, r, m)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "missing or invalid Upload-Offset header", http.StatusBadRequest)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(chunkTimeout))
+	body := http.MaxBytesReader(w, r.Body, maxChunkSize)
+
+	u, err = m.write(u.ID, offset, body)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	var tooBig *http.MaxBytesError
+	switch {
+	case errors.Is(err, errOffsetMismatch), errors.Is(err, errUploadBusy):
+		writeJSON(w, http.StatusConflict, u)
+		return
+	case errors.Is(err, errTooMuchData), errors.As(err, &tooBig):
+		http.Error(w, "chunk too large", http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		logger.Warn("Upload chunk cut short", "upload", u.ID, "offset", u.Offset, "err", err)
+		http.Error(w, "failed to write chunk", http.StatusInternalServerError)
+		return
+	}
+
+	if u.Done {
+		logger.Info("Upload finished", "upload", u.ID, "path", u.Path, "size", u.Size)
+		s.auditUpload(r, u)
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// handleAbortUpload throws an unfinished upload away
+func (s *Server) handleAbortUpload(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.media(w)
+	if !ok {
+		return
+	}
+	u, ok := s.ownUpload(w, r, m)
+	if !ok {
+		return
+	}
+	if err := m.abort(u.ID); errors.Is(err, errUploadBusy) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to abort upload", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownUpload loads the upload named in the URL. With auth on, only whoever
+// started an upload may touch it.
+func (s *Server) ownUpload(w http.ResponseWriter, r *http.Request, m *mediaStore) (Upload, bool) {
+	u, err := m.upload(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return Upload{}, false
+	}
+	if s.opts.Auth.enabled() && u.User != userFrom(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return Upload{}, false
+	}
+	return u, true
+}
+
+// auditUpload records a finished upload
+func (s *Server) auditUpload(r *http.Request, u Upload) {
+	s.audit.record(AuditEvent{
+		Event:      auditFileUpload,
+		RemoteAddr: r.RemoteAddr,
+		User:       userFrom(r),
+		UserAgent:  r.UserAgent(),
+		File:       u.Path,
+		BytesIn:    u.Size,
+	})
+}
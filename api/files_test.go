@@ -0,0 +1,59 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestAbortBusyUpload(t *testing.T) {
+	m, err := newMediaStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	u, err := m.create("clip.mp4", 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold a chunk open halfway through
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		_, err := m.write(u.ID, 0, pr)
+		written <- err
+	}()
+	pw.Write([]byte("12345"))
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		m.mu.Lock()
+		busy := m.busy[u.ID]
+		m.mu.Unlock()
+		if busy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the chunk never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := m.abort(u.ID); !errors.Is(err, errUploadBusy) {
+		t.Errorf("abort during a chunk = %v, want errUploadBusy", err)
+	}
+	pw.Close()
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if got, err := m.upload(u.ID); err != nil || got.Offset != 5 {
+		t.Fatalf("upload = %+v, %v, want it kept at offset 5", got, err)
+	}
+
+	if err := m.abort(u.ID); err != nil {
+		t.Fatalf("abort = %v", err)
+	}
+	if _, err := m.upload(u.ID); err == nil {
+		t.Error("upload still there after abort")
+	}
+}
@@ -0,0 +1,707 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"marcli/media"
+
+	logger "github.com/charmbracelet/log"
+)
+
+// Job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Default job queue settings, used when Options leaves them at zero
+const (
+	defaultMaxJobs    = 2
+	defaultJobHistory = 100
+)
+
+// JobRequest is the JSON body of POST /api/jobs
+type JobRequest struct {
+	Command string `json:"command"`
+	CommandRequest
+}
+
+// Job is a command run in the background by the job queue. Jobs are saved
+// to disk, so they outlive the server.
+type Job struct {
+	ID      string         `json:"id"`
+	Command string         `json:"command"`
+	Request CommandRequest `json:"request"`
+	User    string         `json:"user,omitempty"`
+	State   string         `json:"state"`
+	// Progress is the last percentage the command printed, if any
+	Progress float64 `json:"progress,omitempty"`
+	// Encode is the latest ffmpeg progress (ETA, speed, clip...) from
+	// commands like mega-combine that report it
+	Encode *media.Progress `json:"encode,omitempty"`
+	// Lines counts the lines of output so far, LastLine is the newest
+	Lines     int       `json:"lines"`
+	LastLine  string    `json:"lastLine,omitempty"`
+	ExitCode  *int      `json:"exitCode,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Submitted time.Time `json:"submitted"`
+	Started   time.Time `json:"started,omitzero"`
+	Finished  time.Time `json:"finished,omitzero"`
+}
+
+// Done reports whether the job has finished, one way or another
+func (j Job) Done() bool {
+	return j.State == JobSucceeded || j.State == JobFailed || j.State == JobCancelled
+}
+
+// progressPattern finds percentages like "42%" or "12.5%" in output lines
+var progressPattern = regexp.MustCompile(`(\d{1,3}(?:\.\d+)?)%`)
+
+var (
+	// errJobNotFound is returned for job IDs the queue doesn't know
+	errJobNotFound = errors.New("job not found")
+	// errShuttingDown is returned for jobs submitted during shutdown
+	errShuttingDown = errors.New("server is shutting down")
+)
+
+// jobEntry is a job plus the bits of it that only live in memory
+type jobEntry struct {
+	job    Job
+	cancel context.CancelFunc
+	// log stays open for appending while the job runs. Only the job's own
+	// goroutine touches it.
+	log *os.File
+	// cancelled is set when a user cancels a running job, so it isn't
+	// mistaken for a failure
+	cancelled bool
+	// changed is closed and replaced whenever the job or its log changes
+	changed chan struct{}
+}
+
+// jobQueue runs jobs in the background, at most limit at a time, saving
+// each one's state as <id>.json and its output as <id>.log in dir
+type jobQueue struct {
+	dir     string
+	limit   int
+	history int
+	resolve func(name string, req CommandRequest) (Spec, error)
+
+	mu      sync.Mutex
+	jobs    map[string]*jobEntry
+	running int
+	started bool
+	stopped bool
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newJobQueue loads the jobs saved in the options' jobs dir. Jobs that were
+// queued or running when the server stopped are queued again, and run once
+// the queue is started.
+func newJobQueue(opts Options) (*jobQueue, error) {
+	q := &jobQueue{
+		dir:     opts.JobsDir,
+		limit:   opts.MaxJobs,
+		history: opts.JobHistory,
+		resolve: opts.commandSpec,
+		jobs:    make(map[string]*jobEntry),
+		quit:    make(chan struct{}),
+	}
+	if q.dir == "" {
+		q.dir = defaultJobsDir()
+	}
+	if q.limit <= 0 {
+		q.limit = defaultMaxJobs
+	}
+	if q.history <= 0 {
+		q.history = defaultJobHistory
+	}
+
+	if err := os.MkdirAll(q.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create jobs directory: %w", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job: %w", err)
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			logger.Warn("Skipping unreadable job", "path", path, "err", err)
+			continue
+		}
+		entry := &jobEntry{job: job, changed: make(chan struct{})}
+		if job.State == JobRunning {
+			// The server went away mid-run, so start it over
+			entry.job.State = JobQueued
+			entry.job.Started = time.Time{}
+			q.appendLog(entry, "--- server restarted, running the job again ---")
+			q.save(entry)
+		}
+		q.jobs[job.ID] = entry
+	}
+
+	q.mu.Lock()
+	q.pruneLocked()
+	q.mu.Unlock()
+	return q, nil
+}
+
+// start starts running queued jobs. Until then jobs are only queued.
+func (q *jobQueue) start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.started = true
+	q.dispatchLocked()
+}
+
+// defaultJobsDir returns where jobs go when no dir is configured
+func defaultJobsDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "jobs"
+	}
+	return filepath.Join(home, ".marcli", "jobs")
+}
+
+// submit queues a command to run in the background
+func (q *jobQueue) submit(req JobRequest, user string) (Job, error) {
+	// Check the request now so bad ones fail fast instead of in the queue
+	if _, err := q.resolve(req.Command, req.CommandRequest); err != nil {
+		return Job{}, err
+	}
+	id, err := randomID(8)
+	if err != nil {
+		return Job{}, err
+	}
+
+	entry := &jobEntry{
+		job: Job{
+			ID:        id,
+			Command:   req.Command,
+			Request:   req.CommandRequest,
+			User:      user,
+			State:     JobQueued,
+			Submitted: time.Now(),
+		},
+		changed: make(chan struct{}),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return Job{}, errShuttingDown
+	}
+	// Create the log up front so it can be followed before the job starts
+	if err := os.WriteFile(q.logPath(id), nil, 0600); err != nil {
+		return Job{}, fmt.Errorf("failed to create job log: %w", err)
+	}
+	q.jobs[id] = entry
+	q.save(entry)
+	q.pruneLocked()
+	q.dispatchLocked()
+	return entry.job, nil
+}
+
+// get returns a snapshot of a job
+func (q *jobQueue) get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return entry.job, true
+}
+
+// list returns every job, newest first
+func (q *jobQueue) list() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, entry := range q.jobs {
+		jobs = append(jobs, entry.job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Submitted.After(jobs[j].Submitted)
+	})
+	return jobs
+}
+
+// watch returns a snapshot of a job and a channel that's closed the next
+// time it changes
+func (q *jobQueue) watch(id string) (Job, <-chan struct{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry, ok := q.jobs[id]
+	if !ok {
+		return Job{}, nil, false
+	}
+	return entry.job, entry.changed, true
+}
+
+// cancel stops a job: queued jobs never run, running ones are interrupted
+// and killed if they don't exit within their grace period
+func (q *jobQueue) cancel(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry, ok := q.jobs[id]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+
+	switch entry.job.State {
+	case JobQueued:
+		entry.job.State = JobCancelled
+		entry.job.Finished = time.Now()
+		q.save(entry)
+		q.notifyLocked(entry)
+	case JobRunning:
+		entry.cancelled = true
+		entry.cancel()
+	default:
+		return entry.job, fmt.Errorf("job already %s", entry.job.State)
+	}
+	return entry.job, nil
+}
+
+// dispatchLocked starts queued jobs, oldest first, until the limit is hit
+func (q *jobQueue) dispatchLocked() {
+	if !q.started || q.stopped {
+		return
+	}
+	var queued []*jobEntry
+	for _, entry := range q.jobs {
+		if entry.job.State == JobQueued {
+			queued = append(queued, entry)
+		}
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		return queued[i].job.Submitted.Before(queued[j].job.Submitted)
+	})
+
+	for _, entry := range queued {
+		if q.running >= q.limit {
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		entry.cancel = cancel
+		entry.job.State = JobRunning
+		entry.job.Started = time.Now()
+		q.save(entry)
+		q.notifyLocked(entry)
+		q.running++
+		q.wg.Add(1)
+		go q.run(ctx, entry)
+	}
+}
+
+// run runs a job and records how it ended
+func (q *jobQueue) run(ctx context.Context, entry *jobEntry) {
+	defer q.wg.Done()
+	defer entry.cancel()
+
+	q.mu.Lock()
+	job := entry.job
+	q.mu.Unlock()
+
+	log, err := os.OpenFile(q.logPath(job.ID), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		logger.Error("Failed to open job log", "job", job.ID, "err", err)
+	} else {
+		entry.log = log
+		defer func() {
+			entry.log = nil
+			log.Close()
+		}()
+	}
+
+	logger.Info("Job started", "job", job.ID, "command", job.Command, "user", job.User)
+	code := -1
+	spec, err := q.resolve(job.Command, job.Request)
+	if err == nil {
+		code, err = runCommand(ctx, spec, func(line string) {
+			q.appendLog(entry, line)
+		})
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running--
+
+	switch {
+	case entry.cancelled:
+		entry.job.State = JobCancelled
+	case q.stopped && ctx.Err() != nil:
+		// Interrupted by shutdown, so it runs again next time
+		entry.job.State = JobQueued
+		entry.job.Started = time.Time{}
+		entry.job.Progress = 0
+		entry.job.Encode = nil
+		q.appendLogLocked(entry, "--- server shutting down, the job will run again ---")
+		q.save(entry)
+		q.notifyLocked(entry)
+		logger.Info("Job interrupted by shutdown", "job", job.ID)
+		return
+	case err != nil || code != 0:
+		entry.job.State = JobFailed
+	default:
+		entry.job.State = JobSucceeded
+		entry.job.Progress = 100
+	}
+	entry.job.ExitCode = &code
+	if err != nil {
+		entry.job.Error = err.Error()
+	}
+	entry.job.Finished = time.Now()
+	q.save(entry)
+	q.notifyLocked(entry)
+	logger.Info("Job finished", "job", job.ID, "state", entry.job.State, "exitCode", code)
+
+	q.pruneLocked()
+	q.dispatchLocked()
+}
+
+// appendLog adds a line of output to a job's log and picks up its progress
+func (q *jobQueue) appendLog(entry *jobEntry, line string) {
+	// Only the job's own goroutine writes to its log, so this needs no lock
+	q.writeLog(entry, line)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.noteLineLocked(entry, line)
+}
+
+func (q *jobQueue) appendLogLocked(entry *jobEntry, line string) {
+	q.writeLog(entry, line)
+	q.noteLineLocked(entry, line)
+}
+
+// writeLog writes a line to a job's log, through the open handle while it
+// runs
+func (q *jobQueue) writeLog(entry *jobEntry, line string) {
+	f := entry.log
+	if f == nil {
+		var err error
+		f, err = os.OpenFile(q.logPath(entry.job.ID), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			logger.Error("Failed to open job log", "job", entry.job.ID, "err", err)
+			return
+		}
+		defer f.Close()
+	}
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		logger.Error("Failed to write job log", "job", entry.job.ID, "err", err)
+	}
+}
+
+// noteLineLocked counts a line of output and picks up its progress
+func (q *jobQueue) noteLineLocked(entry *jobEntry, line string) {
+	entry.job.Lines++
+	entry.job.LastLine = line
+	if m := progressPattern.FindAllStringSubmatch(line, -1); m != nil {
+		if pct, err := strconv.ParseFloat(m[len(m)-1][1], 64); err == nil && pct <= 100 {
+			entry.job.Progress = pct
+		}
+	}
+	if p, ok := media.ParseProgressLine(line); ok {
+		entry.job.Encode = &p
+	}
+	q.notifyLocked(entry)
+}
+
+// notifyLocked wakes up everyone watching a job
+func (q *jobQueue) notifyLocked(entry *jobEntry) {
+	close(entry.changed)
+	entry.changed = make(chan struct{})
+}
+
+// save writes a job's state to disk, replacing the old file in one go
+func (q *jobQueue) save(entry *jobEntry) {
+	data, err := json.MarshalIndent(entry.job, "", "  ")
+	if err != nil {
+		logger.Error("Failed to encode job", "job", entry.job.ID, "err", err)
+		return
+	}
+	path := filepath.Join(q.dir, entry.job.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		logger.Error("Failed to save job", "job", entry.job.ID, "err", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		logger.Error("Failed to save job", "job", entry.job.ID, "err", err)
+	}
+}
+
+// pruneLocked forgets the oldest finished jobs beyond the history limit
+func (q *jobQueue) pruneLocked() {
+	var done []*jobEntry
+	for _, entry := range q.jobs {
+		if entry.job.Done() {
+			done = append(done, entry)
+		}
+	}
+	if len(done) <= q.history {
+		return
+	}
+	sort.Slice(done, func(i, j int) bool {
+		return done[i].job.Submitted.Before(done[j].job.Submitted)
+	})
+	for _, entry := range done[:len(done)-q.history] {
+		delete(q.jobs, entry.job.ID)
+		os.Remove(filepath.Join(q.dir, entry.job.ID+".json"))
+		os.Remove(q.logPath(entry.job.ID))
+	}
+}
+
+// logPath is where a job's output goes
+func (q *jobQueue) logPath(id string) string {
+	return filepath.Join(q.dir, id+".log")
+}
+
+// stop stops starting jobs and interrupts the running ones, which are
+// queued again for the next start. Log followers are told to go away.
+func (q *jobQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return
+	}
+	q.stopped = true
+	close(q.quit)
+	for _, entry := range q.jobs {
+		if entry.job.State == JobRunning {
+			entry.cancel()
+		}
+	}
+}
+
+// wait waits for interrupted jobs to exit
+func (q *jobQueue) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// jobsData is what jobs.html gets rendered with
+type jobsData struct {
+	User string
+}
+
+// handleJobsPage renders the page listing background jobs
+func (s *Server) handleJobsPage(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := s.assets.page("jobs.html")
+	if err != nil {
+		logger.Error("Failed to load jobs.html", "err", err)
+		http.Error(w, "failed to load page", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, jobsData{User: userFrom(r)}); err != nil {
+		logger.Error("Failed to render jobs.html", "err", err)
+	}
+}
+
+// handleListJobs lists every job, newest first
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.jobs.list())
+}
+
+// handleSubmitJob queues a command to run in the background
+func (s *Server) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var req JobRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.jobs.submit(req, userFrom(r))
+	if errors.Is(err, errShuttingDown) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.Info("Job submitted", "job", job.ID, "command", job.Command, "user", job.User, "remote", r.RemoteAddr)
+	s.audit.record(AuditEvent{
+		Event:      auditJobSubmit,
+		JobID:      job.ID,
+		RemoteAddr: r.RemoteAddr,
+		User:       userFrom(r),
+		UserAgent:  r.UserAgent(),
+		Command:    job.Command,
+	})
+
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// handleGetJob returns a job's status and progress
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, errJobNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleCancelJob cancels a job. With auth on, only whoever submitted it or
+// an admin may.
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	id := r.PathValue("id")
+	job, ok := s.jobs.get(id)
+	if !ok {
+		http.Error(w, errJobNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	user := userFrom(r)
+	if s.opts.Auth.enabled() && job.User != user && !s.opts.Auth.isAdmin(user) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	job, err := s.jobs.cancel(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	logger.Info("Job cancelled", "job", id, "user", user, "remote", r.RemoteAddr)
+	s.audit.record(AuditEvent{
+		Event:      auditJobCancel,
+		JobID:      id,
+		RemoteAddr: r.RemoteAddr,
+		User:       user,
+		UserAgent:  r.UserAgent(),
+		Command:    job.Command,
+	})
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleJobLog returns a job's output as plain text, or follows it as
+// Server-Sent Events if the client asks for text/event-stream: "output" for
+// each line, "status" when the job's state or progress (including its encode
+// progress) changes and "exit" once it's done
+func (s *Server) handleJobLog(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.jobs.get(id); !ok {
+		http.Error(w, errJobNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	f, err := os.Open(s.jobs.logPath(id))
+	if err != nil {
+		logger.Error("Failed to open job log", "job", id, "err", err)
+		http.Error(w, "failed to open job log", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		io.Copy(w, f)
+		return
+	}
+
+	// Following a log can take far longer than the HTTP write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	reader := bufio.NewReader(f)
+	var partial string
+	var last Job
+	for {
+		// Grab the change channel before reading so nothing slips between
+		job, changed, ok := s.jobs.watch(id)
+		if !ok {
+			return
+		}
+
+		for {
+			chunk, err := reader.ReadString('\n')
+			if err != nil {
+				partial += chunk
+				break
+			}
+			data, _ := json.Marshal(strings.TrimSuffix(partial+chunk, "\n"))
+			partial = ""
+			writeEvent(w, "output", data)
+		}
+		if job.State != last.State || job.Progress != last.Progress || job.Encode != last.Encode {
+			data, _ := json.Marshal(job)
+			writeEvent(w, "status", data)
+			last = job
+		}
+		if job.Done() {
+			data, _ := json.Marshal(job)
+			writeEvent(w, "exit", data)
+			rc.Flush()
+			return
+		}
+		rc.Flush()
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-s.jobs.quit:
+			return
+		}
+	}
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobsRequeuedOnStart(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test script needs sh")
+	}
+	dir := t.TempDir()
+
+	// A job that was running when the server went away
+	job := Job{ID: "leftover", Command: "count", State: JobRunning, Submitted: time.Now(), Started: time.Now()}
+	data, _ := json.Marshal(job)
+	os.WriteFile(filepath.Join(dir, "leftover.json"), data, 0600)
+	os.WriteFile(filepath.Join(dir, "leftover.log"), []byte("half done\n"), 0600)
+
+	s, err := NewServer(Options{
+		JobsDir: dir,
+		Scripts: []Profile{{Name: "count", Argv: []string{"sh", "-c", "echo one; echo 50%; echo two"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing runs until the server starts
+	time.Sleep(100 * time.Millisecond)
+	if got, _ := s.jobs.get("leftover"); got.State != JobQueued {
+		t.Fatalf("before Start the job is %s, want %s", got.State, JobQueued)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		<-s.Done()
+	}()
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		got, _ := s.jobs.get("leftover")
+		if got.Done() {
+			if got.State != JobSucceeded || got.Progress != 100 || got.LastLine != "two" {
+				t.Errorf("job ended as %+v", got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job never finished: %+v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	log, _ := os.ReadFile(filepath.Join(dir, "leftover.log"))
+	want := "half done\n--- server restarted, running the job again ---\none\n50%\ntwo\n"
+	if string(log) != want {
+		t.Errorf("log = %q, want %q", log, want)
+	}
+}
+
+func TestJobsAppendLog(t *testing.T) {
+	q, err := newJobQueue(Options{JobsDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &jobEntry{job: Job{ID: "j"}, changed: make(chan struct{})}
+	q.jobs["j"] = entry
+
+	q.appendLog(entry, "encoding 42.5% done")
+	q.appendLog(entry, "progress 60.0% clip=2/3 time=6.0s/10.0s speed=2.00x size=1024 eta=2s")
+	got, _ := q.get("j")
+	if got.Lines != 2 || got.Progress != 60 || got.Encode == nil || got.Encode.Clip != 2 {
+		t.Errorf("job after two lines = %+v", got)
+	}
+	log, _ := os.ReadFile(q.logPath("j"))
+	if lines := strings.Count(string(log), "\n"); lines != 2 {
+		t.Errorf("log has %d lines, want 2", lines)
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sessionDurationBuckets are the upper bounds (in seconds) of the session
+// duration histogram
+var sessionDurationBuckets = []float64{10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600}
+
+// metrics counts what the server has been up to, for /metrics
+type metrics struct {
+	sessionsTotal    atomic.Int64
+	bytesIn          atomic.Int64
+	bytesOut         atomic.Int64
+	ptyStartFailures atomic.Int64
+	websocketErrors  atomic.Int64
+
+	mu            sync.Mutex
+	durationCount []int64
+	durationSum   float64
+	durationTotal int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{durationCount: make([]int64, len(sessionDurationBuckets))}
+}
+
+// observeSessionDuration adds a finished session to the duration histogram
+func (m *metrics) observeSessionDuration(d time.Duration) {
+	seconds := d.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, bound := range sessionDurationBuckets {
+		if seconds <= bound {
+			m.durationCount[i]++
+		}
+	}
+	m.durationSum += seconds
+	m.durationTotal++
+}
+
+// write renders the metrics in the Prometheus text format
+func (m *metrics) write(w io.Writer, activeSessions, spectators int) {
+	gauge := func(name, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+	}
+	counter := func(name, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+
+	gauge("marcli_tty_sessions_active", "Terminal sessions currently running.", int64(activeSessions))
+	gauge("marcli_tty_spectators_active", "Spectators currently watching a session.", int64(spectators))
+	counter("marcli_tty_sessions_total", "Terminal sessions started.", m.sessionsTotal.Load())
+	counter("marcli_tty_bytes_in_total", "Bytes typed into terminals.", m.bytesIn.Load())
+	counter("marcli_tty_bytes_out_total", "Bytes of terminal output.", m.bytesOut.Load())
+	counter("marcli_tty_pty_start_failures_total", "Terminals that failed to start.", m.ptyStartFailures.Load())
+	counter("marcli_tty_websocket_errors_total", "WebSocket connections that failed or broke.", m.websocketErrors.Load())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := "marcli_tty_session_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s How long terminal sessions lasted.\n# TYPE %s histogram\n", name, name)
+	for i, bound := range sessionDurationBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, m.durationCount[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, m.durationTotal)
+	fmt.Fprintf(w, "%s_sum %g\n", name, m.durationSum)
+	fmt.Fprintf(w, "%s_count %d\n", name, m.durationTotal)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/coder/websocket"
+)
+
+// WebSocket protocol: binary messages carry raw terminal bytes in both
+// directions, text messages carry JSON control messages.
+
+// controlMessage is a JSON control message sent over the WebSocket
+type controlMessage struct {
+	Type  string `json:"type"`
+	Cols  uint16 `json:"cols,omitempty"`
+	Rows  uint16 `json:"rows,omitempty"`
+	Count int    `json:"count,omitempty"`
+	Token string `json:"token,omitempty"`
+	// Reason and Seconds are set on msgWarning
+	Reason  string `json:"reason,omitempty"`
+	Seconds int    `json:"seconds,omitempty"`
+	// Exit is set on msgExited
+	Exit *ExitStatus `json:"exit,omitempty"`
+}
+
+// Control message types
+const (
+	// msgResize is sent by the browser when the terminal size changes
+	msgResize = "resize"
+	// msgShare asks for a spectator link; the server answers with msgShared
+	msgShare = "share"
+	// msgUnshare revokes the spectator link and disconnects spectators
+	msgUnshare = "unshare"
+	// msgShared carries the share token for the spectator link
+	msgShared = "shared"
+	// msgViewers tells everyone how many spectators are watching
+	msgViewers = "viewers"
+	// msgSize tells spectators the owner's terminal size
+	msgSize = "size"
+	// msgShutdown warns browsers that the server is going away
+	msgShutdown = "shutdown"
+	// msgExited tells everyone the program ended, with its exit status
+	msgExited = "exited"
+	// msgWarning warns the browser the session is about to be closed (Reason
+	// is "idle" or "lifetime") in Seconds
+	msgWarning = "warning"
+)
+
+// Close codes (in the 4000-4999 range reserved for applications) telling
+// the browser why the server ended a session
+const (
+	// statusIdleTimeout means nobody typed anything for too long
+	statusIdleTimeout websocket.StatusCode = 4000
+	// statusMaxLifetime means the session hit its maximum lifetime
+	statusMaxLifetime websocket.StatusCode = 4001
+)
+
+// parseControlMessage decodes a control message from a text frame
+func parseControlMessage(data []byte) (controlMessage, error) {
+	var msg controlMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, fmt.Errorf("invalid control message: %w", err)
+	}
+	if msg.Type == "" {
+		return msg, fmt.Errorf("control message has no type")
+	}
+	return msg, nil
+}
+
+// encodeControlMessage encodes a control message for a text frame
+func encodeControlMessage(msg controlMessage) []byte {
+	data, _ := json.Marshal(msg)
+	return data
+}
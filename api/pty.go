@@ -4,13 +4,18 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"sync"
+	"syscall"
+	"time"
 
+	logger "github.com/charmbracelet/log"
 	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
 )
 
 // PTYManager manages a single PTY instance
@@ -19,6 +24,8 @@ type PTYManager struct {
 	cmd    *exec.Cmd
 	mu     sync.Mutex
 	closed bool
+	exit   *exitState
+	grace  time.Duration
 }
 
 // NewPTYManager creates a new PTY manager
@@ -26,25 +33,39 @@ func NewPTYManager() *PTYManager {
 	return &PTYManager{}
 }
 
-// Start starts a new PTY with marcli running cutiepie-tui
-func (p *PTYManager) Start() error {
+// Start starts a new PTY running the program described by spec
+func (p *PTYManager) Start(spec Spec) error {
+	// Close existing PTY if any
+	p.Close()
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// Close existing PTY if any
-	if p.ptmx != nil {
-		p.closeLocked()
-	}
+	p.cmd = exec.Command(spec.Path, spec.Args...)
+	p.cmd.Dir = spec.Dir
+	p.cmd.Env = append(os.Environ(), spec.Env...)
 
-	// Find the marcli executable
-	execPath, err := os.Executable()
-	if err != nil {
-		return err
+	// Run as another user if the spec asks for it
+	if spec.UID != nil || spec.GID != nil {
+		cred := &syscall.Credential{
+			Uid: uint32(os.Getuid()),
+			Gid: uint32(os.Getgid()),
+		}
+		if spec.UID != nil {
+			cred.Uid = *spec.UID
+		}
+		if spec.GID != nil {
+			cred.Gid = *spec.GID
+		}
+		p.cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
 	}
 
-	// Create command to run marcli with --stay-alive flag
-	// This ensures the TUI stays open after commands
-	p.cmd = exec.Command(execPath, "--stay-alive")
+	// The command pipe becomes fd 3; our copy is closed once it's started
+	if spec.CommandPipe != nil {
+		p.cmd.ExtraFiles = []*os.File{spec.CommandPipe}
+		p.cmd.Env = append(p.cmd.Env, CommandFDEnv+"=3")
+		defer spec.CommandPipe.Close()
+	}
 
 	// Start the command with a PTY
 	ptmx, err := pty.Start(p.cmd)
@@ -54,15 +75,69 @@ func (p *PTYManager) Start() error {
 
 	p.ptmx = ptmx
 	p.closed = false
+	p.grace = spec.GracePeriod
+	if p.grace <= 0 {
+		p.grace = defaultGracePeriod
+	}
 
 	// Check if process started successfully
 	if p.cmd.Process == nil {
 		return fmt.Errorf("process did not start")
 	}
 
+	// Reap the process as soon as it exits so we know its exit status
+	exit := newExitState()
+	p.exit = exit
+	go func(cmd *exec.Cmd) {
+		err := cmd.Wait()
+		signal := ""
+		if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			signal = unix.SignalName(ws.Signal())
+		}
+		exit.set(cmd.ProcessState.ExitCode(), signal, err)
+	}(p.cmd)
+
 	return nil
 }
 
+// Wait blocks until the process exits
+func (p *PTYManager) Wait() error {
+	p.mu.Lock()
+	exit := p.exit
+	p.mu.Unlock()
+
+	if exit == nil {
+		return fmt.Errorf("process not started")
+	}
+	return exit.wait()
+}
+
+// ExitCode returns the process's exit code, or -1 if it's still running or
+// was killed by a signal
+func (p *PTYManager) ExitCode() int {
+	p.mu.Lock()
+	exit := p.exit
+	p.mu.Unlock()
+
+	if exit == nil {
+		return -1
+	}
+	return exit.exitCode()
+}
+
+// ExitSignal returns the signal that killed the process, or "" if there
+// wasn't one
+func (p *PTYManager) ExitSignal() string {
+	p.mu.Lock()
+	exit := p.exit
+	p.mu.Unlock()
+
+	if exit == nil {
+		return ""
+	}
+	return exit.exitSignal()
+}
+
 // Write writes data to the PTY stdin
 func (p *PTYManager) Write(data []byte) (int, error) {
 	p.mu.Lock()
@@ -75,18 +150,23 @@ func (p *PTYManager) Write(data []byte) (int, error) {
 	return p.ptmx.Write(data)
 }
 
-// Read reads data from the PTY stdout
+// Read reads data from the PTY stdout. It keeps working while Close waits
+// for the program to exit, so the session goes on draining its output.
 func (p *PTYManager) Read(data []byte) (int, error) {
 	p.mu.Lock()
 	ptmx := p.ptmx
-	closed := p.closed
 	p.mu.Unlock()
 
-	if closed || ptmx == nil {
+	if ptmx == nil {
 		return 0, io.EOF
 	}
 
-	return ptmx.Read(data)
+	n, err := ptmx.Read(data)
+	if errors.Is(err, os.ErrClosed) || errors.Is(err, syscall.EIO) {
+		// Closed by us, or EIO on Linux once the program's side hangs up
+		err = io.EOF
+	}
+	return n, err
 }
 
 // Resize resizes the PTY
@@ -106,32 +186,86 @@ func (p *PTYManager) Resize(cols, rows uint16) error {
 	return pty.Setsize(p.ptmx, size)
 }
 
-// Close closes the PTY and kills the command
+// Close asks the command to exit, kills it if it's still running after the
+// grace period, and closes the PTY
 func (p *PTYManager) Close() error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	return p.closeLocked()
-}
-
-func (p *PTYManager) closeLocked() error {
-	if p.closed {
+	if p.closed || p.ptmx == nil {
+		p.closed = true
+		p.mu.Unlock()
 		return nil
 	}
-
 	p.closed = true
+	ptmx, cmd := p.ptmx, p.cmd
+	p.mu.Unlock()
 
-	if p.ptmx != nil {
-		p.ptmx.Close()
+	// Wait without holding the lock, so Read keeps draining the output and
+	// nothing else blocks for the whole grace period
+	p.stop(cmd.Process.Pid, ptmx)
+
+	p.mu.Lock()
+	if p.ptmx == ptmx {
 		p.ptmx = nil
+		p.cmd = nil
 	}
+	p.mu.Unlock()
+	return ptmx.Close()
+}
 
-	if p.cmd != nil && p.cmd.Process != nil {
-		p.cmd.Process.Kill()
-		p.cmd.Wait()
-		p.cmd = nil
+// stop ends the command the way closing a terminal would, so programs like
+// ffmpeg get to finish writing their output: SIGINT to the foreground job
+// (like Ctrl+C) and SIGHUP to the session. Whatever is still running after
+// the grace period gets SIGKILL.
+func (p *PTYManager) stop(pid int, ptmx *os.File) {
+	select {
+	case <-p.exit.done:
+		return
+	default:
 	}
 
-	return nil
+	// The command leads its own session, so its process group id is its pid.
+	// A shell may have put the running job in a group of its own.
+	groups := []int{pid}
+	if fg := foregroundGroup(ptmx); fg > 0 && fg != pid {
+		groups = append(groups, fg)
+		syscall.Kill(-fg, syscall.SIGINT)
+	} else {
+		syscall.Kill(-pid, syscall.SIGINT)
+	}
+	for _, g := range groups {
+		syscall.Kill(-g, syscall.SIGHUP)
+	}
+
+	timer := time.NewTimer(p.grace)
+	defer timer.Stop()
+	select {
+	case <-p.exit.done:
+	case <-timer.C:
+		logger.Warn("Process still running after grace period, killing it", "pid", pid, "grace", p.grace)
+		for _, g := range groups {
+			syscall.Kill(-g, syscall.SIGKILL)
+		}
+		<-p.exit.done
+	}
+}
+
+// foregroundGroup returns the process group of the PTY's foreground job, or
+// 0 if it can't be found
+func foregroundGroup(ptmx *os.File) int {
+	conn, err := ptmx.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	pgrp := 0
+	conn.Control(func(fd uintptr) {
+		pgrp, _ = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	})
+	return pgrp
+}
+
+// closeOnExec keeps f from being inherited by programs we start
+func closeOnExec(f *os.File) {
+	syscall.CloseOnExec(int(f.Fd()))
 }
 
 // IsClosed returns whether the PTY is closed
@@ -140,3 +274,12 @@ func (p *PTYManager) IsClosed() bool {
 	defer p.mu.Unlock()
 	return p.closed
 }
+
+// loginShell returns the user's login shell
+func loginShell() (string, []string) {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	return shell, []string{"-l"}
+}
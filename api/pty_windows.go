@@ -8,17 +8,26 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/UserExistsError/conpty"
+	logger "github.com/charmbracelet/log"
+	"golang.org/x/sys/windows"
 )
 
 // PTYManager manages a single PTY instance on Windows using ConPTY
 type PTYManager struct {
 	cpty   *conpty.ConPty
-	cmd    *exec.Cmd
 	mu     sync.Mutex
 	closed bool
+	exit   *exitState
+	grace  time.Duration
+	// process is our own handle to the child, so we can still wait on and
+	// terminate it after the pseudo console (and its handles) are closed
+	process windows.Handle
 }
 
 // NewPTYManager creates a new PTY manager
@@ -26,37 +35,102 @@ func NewPTYManager() *PTYManager {
 	return &PTYManager{}
 }
 
-// Start starts a new PTY with marcli running cutiepie-tui
-func (p *PTYManager) Start() error {
+// Start starts a new PTY running the program described by spec
+func (p *PTYManager) Start(spec Spec) error {
+	// Close existing PTY if any
+	p.Close()
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// Close existing PTY if any
-	if p.cpty != nil {
-		p.closeLocked()
+	// ConPTY can't hand the program extra handles, so menu commands aren't
+	// reported on Windows
+	if spec.CommandPipe != nil {
+		spec.CommandPipe.Close()
 	}
 
-	// Find the marcli executable
-	execPath, err := os.Executable()
-	if err != nil {
-		return err
+	if spec.UID != nil || spec.GID != nil {
+		return fmt.Errorf("running as another uid/gid is not supported on Windows")
 	}
 
-	// Create command line with --stay-alive flag
-	commandLine := fmt.Sprintf(`"%s" --stay-alive`, execPath)
+	// Build a properly quoted command line
+	parts := []string{syscall.EscapeArg(spec.Path)}
+	for _, arg := range spec.Args {
+		parts = append(parts, syscall.EscapeArg(arg))
+	}
+	commandLine := strings.Join(parts, " ")
+
+	options := []conpty.ConPtyOption{
+		conpty.ConPtyEnv(append(os.Environ(), spec.Env...)),
+	}
+	if spec.Dir != "" {
+		options = append(options, conpty.ConPtyWorkDir(spec.Dir))
+	}
 
 	// Start the command with ConPTY
-	cpty, err := conpty.Start(commandLine)
+	cpty, err := conpty.Start(commandLine, options...)
 	if err != nil {
 		return fmt.Errorf("unsupported: %w", err)
 	}
 
+	process, err := windows.OpenProcess(windows.SYNCHRONIZE|windows.PROCESS_TERMINATE|windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(cpty.Pid()))
+	if err != nil {
+		cpty.Close()
+		return fmt.Errorf("failed to open process: %w", err)
+	}
+
 	p.cpty = cpty
+	p.process = process
 	p.closed = false
+	p.grace = spec.GracePeriod
+	if p.grace <= 0 {
+		p.grace = defaultGracePeriod
+	}
+
+	// Watch for the process exiting so we know its exit status
+	exit := newExitState()
+	p.exit = exit
+	go func() {
+		var code uint32
+		_, err := windows.WaitForSingleObject(process, windows.INFINITE)
+		if err == nil {
+			err = windows.GetExitCodeProcess(process, &code)
+		}
+		exit.set(int(code), "", err)
+	}()
 
 	return nil
 }
 
+// Wait blocks until the process exits
+func (p *PTYManager) Wait() error {
+	p.mu.Lock()
+	exit := p.exit
+	p.mu.Unlock()
+
+	if exit == nil {
+		return fmt.Errorf("process not started")
+	}
+	return exit.wait()
+}
+
+// ExitCode returns the process's exit code, or -1 if it's still running
+func (p *PTYManager) ExitCode() int {
+	p.mu.Lock()
+	exit := p.exit
+	p.mu.Unlock()
+
+	if exit == nil {
+		return -1
+	}
+	return exit.exitCode()
+}
+
+// ExitSignal always returns "" - Windows has no signals
+func (p *PTYManager) ExitSignal() string {
+	return ""
+}
+
 // Write writes data to the PTY stdin
 func (p *PTYManager) Write(data []byte) (int, error) {
 	p.mu.Lock()
@@ -69,18 +143,23 @@ func (p *PTYManager) Write(data []byte) (int, error) {
 	return p.cpty.Write(data)
 }
 
-// Read reads data from the PTY stdout
+// Read reads data from the PTY stdout. It keeps working while Close waits
+// for the program to exit, so the session goes on draining its output.
 func (p *PTYManager) Read(data []byte) (int, error) {
 	p.mu.Lock()
 	cpty := p.cpty
-	closed := p.closed
 	p.mu.Unlock()
 
-	if closed || cpty == nil {
+	if cpty == nil {
 		return 0, io.EOF
 	}
 
-	return cpty.Read(data)
+	n, err := cpty.Read(data)
+	if err != nil && p.IsClosed() {
+		// The pipe was closed under us
+		err = io.EOF
+	}
+	return n, err
 }
 
 // Resize resizes the PTY
@@ -95,28 +174,47 @@ func (p *PTYManager) Resize(cols, rows uint16) error {
 	return p.cpty.Resize(int(cols), int(rows))
 }
 
-// Close closes the PTY and kills the command
+// Close closes the pseudo console, which asks the command to exit, and
+// terminates it if it's still running after the grace period
 func (p *PTYManager) Close() error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	return p.closeLocked()
-}
-
-func (p *PTYManager) closeLocked() error {
-	if p.closed {
+	if p.closed || p.cpty == nil {
+		p.closed = true
+		p.mu.Unlock()
 		return nil
 	}
-
 	p.closed = true
+	cpty, process := p.cpty, p.process
+	p.mu.Unlock()
 
-	if p.cpty != nil {
-		p.cpty.Close()
-		p.cpty = nil
+	// Closing the pseudo console blocks until its output has been read, so
+	// this runs without the lock and the session's Read keeps draining it.
+	// Console programs get CTRL_CLOSE_EVENT.
+	cpty.Close()
+
+	timer := time.NewTimer(p.grace)
+	select {
+	case <-p.exit.done:
+	case <-timer.C:
+		logger.Warn("Process still running after grace period, terminating it", "grace", p.grace)
+		windows.TerminateProcess(process, 1)
+		<-p.exit.done
 	}
+	timer.Stop()
+	windows.CloseHandle(process)
 
+	p.mu.Lock()
+	if p.cpty == cpty {
+		p.cpty = nil
+		p.process = 0
+	}
+	p.mu.Unlock()
 	return nil
 }
 
+// closeOnExec does nothing - Windows only passes on handles marked inheritable
+func closeOnExec(f *os.File) {}
+
 // IsClosed returns whether the PTY is closed
 func (p *PTYManager) IsClosed() bool {
 	p.mu.Lock()
@@ -124,3 +222,15 @@ func (p *PTYManager) IsClosed() bool {
 	return p.closed
 }
 
+// loginShell returns the user's shell - PowerShell if we can find it
+func loginShell() (string, []string) {
+	for _, ps := range []string{"pwsh.exe", "powershell.exe"} {
+		if path, err := exec.LookPath(ps); err == nil {
+			return path, []string{"-NoLogo"}
+		}
+	}
+	if comspec := os.Getenv("COMSPEC"); comspec != "" {
+		return comspec, nil
+	}
+	return "cmd.exe", nil
+}
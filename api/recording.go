@@ -0,0 +1,288 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// castExt is the file extension for asciicast recordings
+const castExt = ".cast"
+
+// CastHeader is the first line of an asciicast v2 file
+type CastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// CastEvent is a single asciicast v2 event: "o" for output, "r" for resize
+// (data is "COLSxROWS")
+type CastEvent struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// Cast is a fully loaded asciicast recording
+type Cast struct {
+	Header CastHeader
+	Events []CastEvent
+}
+
+// Duration returns the time of the last event
+func (c *Cast) Duration() time.Duration {
+	if len(c.Events) == 0 {
+		return 0
+	}
+	return time.Duration(c.Events[len(c.Events)-1].Time * float64(time.Second))
+}
+
+// Recorder writes a terminal session to disk in asciicast v2 format
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	start   time.Time
+	pending []byte // trailing bytes of an incomplete UTF-8 sequence
+	path    string
+}
+
+// NewRecorder creates a new recording in dir for a terminal of the given size
+func NewRecorder(dir, title string, cols, rows uint16) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create recordings dir: %w", err)
+	}
+
+	start := time.Now()
+	id, err := randomID(4)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s%s", start.Format("20060102-150405"), id, castExt))
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+
+	r := &Recorder{
+		file:  file,
+		w:     bufio.NewWriter(file),
+		start: start,
+		path:  path,
+	}
+
+	header, err := json.Marshal(CastHeader{
+		Version:   2,
+		Width:     int(cols),
+		Height:    int(rows),
+		Timestamp: start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r.w.Write(header)
+	r.w.WriteByte('\n')
+
+	return r, nil
+}
+
+// Path returns where the recording is being written
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+// Output records a chunk of terminal output
+func (r *Recorder) Output(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// PTY reads can split a multi-byte character, and asciicast stores output
+	// as JSON strings, so hold back any incomplete trailing sequence
+	buf := append(r.pending, data...)
+	cut := len(buf)
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(buf[i]) {
+			if !utf8.FullRune(buf[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte(nil), buf[cut:]...)
+
+	if cut > 0 {
+		r.writeEventLocked("o", string(buf[:cut]))
+	}
+}
+
+// Resize records a terminal resize
+func (r *Recorder) Resize(cols, rows uint16) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeEventLocked("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (r *Recorder) writeEventLocked(typ, data string) {
+	if r.file == nil {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	line, err := json.Marshal([]interface{}{elapsed, typ, data})
+	if err != nil {
+		return
+	}
+	r.w.Write(line)
+	r.w.WriteByte('\n')
+}
+
+// Close flushes and closes the recording
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	if len(r.pending) > 0 {
+		r.writeEventLocked("o", string(r.pending))
+		r.pending = nil
+	}
+
+	flushErr := r.w.Flush()
+	closeErr := r.file.Close()
+	r.file = nil
+	if flushErr != nil {
+		return flushErr
+	}
+	return closeErr
+}
+
+// DefaultRecordingsDir returns where recordings go when no dir is configured
+func DefaultRecordingsDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "recordings"
+	}
+	return filepath.Join(home, ".marcli", "recordings")
+}
+
+// RecordingInfo describes a recording on disk
+type RecordingInfo struct {
+	Name    string
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ListRecordings returns the recordings in dir, newest first
+func ListRecordings(dir string) ([]RecordingInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var recordings []RecordingInfo
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != castExt {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		recordings = append(recordings, RecordingInfo{
+			Name:    strings.TrimSuffix(entry.Name(), castExt),
+			Path:    filepath.Join(dir, entry.Name()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].ModTime.After(recordings[j].ModTime)
+	})
+
+	return recordings, nil
+}
+
+// FindRecording resolves a recording name (with or without .cast) to its file
+// in dir. Only plain names are accepted and the file is looked up through an
+// os.Root, so neither paths nor symlinks can reach anything outside dir.
+func FindRecording(dir, name string) (string, error) {
+	if name == "" || filepath.Base(name) != name || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid recording name %q - use a name from `marcli recordings list`", name)
+	}
+	file := strings.TrimSuffix(name, castExt) + castExt
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return "", fmt.Errorf("recording %q not found in %s", name, dir)
+	}
+	defer root.Close()
+	if info, err := root.Stat(file); err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("recording %q not found in %s", name, dir)
+	}
+	return filepath.Join(dir, file), nil
+}
+
+// LoadRecording reads an asciicast v2 file
+func LoadRecording(path string) (*Cast, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+
+	var cast Cast
+	if err := json.Unmarshal(scanner.Bytes(), &cast.Header); err != nil {
+		return nil, fmt.Errorf("invalid asciicast header: %w", err)
+	}
+	if cast.Header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", cast.Header.Version)
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		var raw []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil || len(raw) != 3 {
+			return nil, fmt.Errorf("invalid event on line %d", line)
+		}
+		t, ok1 := raw[0].(float64)
+		typ, ok2 := raw[1].(string)
+		data, ok3 := raw[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, fmt.Errorf("invalid event on line %d", line)
+		}
+		cast.Events = append(cast.Events, CastEvent{Time: t, Type: typ, Data: data})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &cast, nil
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindRecording(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "recordings")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "demo.cast"), []byte(`{"version": 2}`+"\n"), 0644)
+	os.Mkdir(filepath.Join(dir, "folder.cast"), 0755)
+	secret := filepath.Join(base, "secret.cast")
+	os.WriteFile(secret, []byte(`{"version": 2}`+"\n"), 0644)
+	symlinks := os.Symlink(secret, filepath.Join(dir, "link.cast")) == nil
+
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{name: "demo"},
+		{name: "demo.cast"},
+		{name: "", err: "invalid recording name"},
+		{name: secret, err: "invalid recording name"},
+		{name: "../secret", err: "invalid recording name"},
+		{name: "../secret.cast", err: "invalid recording name"},
+		{name: `..\secret.cast`, err: "invalid recording name"},
+		{name: "sub/demo", err: "invalid recording name"},
+		{name: "..", err: "not found"},
+		{name: "missing", err: "not found"},
+		{name: "folder", err: "not found"},
+		{name: "link", err: "not found"},
+	}
+	for _, tt := range tests {
+		if tt.name == "link" && !symlinks {
+			continue
+		}
+		path, err := FindRecording(dir, tt.name)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("FindRecording(%q) = %q, %v, want an error containing %q", tt.name, path, err, tt.err)
+			}
+			continue
+		}
+		if want := filepath.Join(dir, "demo.cast"); err != nil || path != want {
+			t.Errorf("FindRecording(%q) = %q, %v, want %q", tt.name, path, err, want)
+		}
+	}
+}
@@ -4,139 +4,683 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
-	"path/filepath"
 	"sync"
+	"time"
 
+	logger "github.com/charmbracelet/log"
 	"github.com/coder/websocket"
 )
 
-var (
-	currentPTY *PTYManager
-	ptyMutex   sync.Mutex
+// Options configures the web terminal server
+type Options struct {
+	// Port is the TCP port to listen on (0 picks a random free port)
+	Port int `yaml:"port"`
+	// AllowedOrigins lists extra origins (besides the server's own host) that
+	// may open a WebSocket, e.g. "marcy.cloud" or "https://*.marcy.cloud"
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	// StaticDir serves the web UI from this directory instead of the copy
+	// embedded in the binary - handy for frontend development
+	StaticDir string `yaml:"staticDir"`
+	// Record saves every session as an asciicast v2 recording
+	Record bool `yaml:"record"`
+	// RecordingsDir is where recordings go (default ~/.marcli/recordings)
+	RecordingsDir string `yaml:"recordingsDir"`
+	// ReadTimeout, WriteTimeout and IdleTimeout bound plain HTTP requests.
+	// WebSockets clear them once upgraded.
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	IdleTimeout  time.Duration `yaml:"idleTimeout"`
+	// ShutdownTimeout is how long shutdown waits for sessions to drain when
+	// the server's context is cancelled
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// KillGracePeriod is how long a session's program gets to exit after
+	// being asked to (SIGINT/SIGHUP, or closing the console on Windows)
+	// before it's killed
+	KillGracePeriod time.Duration `yaml:"killGracePeriod"`
+	// PingInterval is how often each WebSocket is pinged; a connection that
+	// doesn't answer within PongTimeout is treated as dead and closed
+	PingInterval time.Duration `yaml:"pingInterval"`
+	PongTimeout  time.Duration `yaml:"pongTimeout"`
+	// SessionIdleTimeout closes a session nobody has typed into for this long
+	// (0 means never). The browser is warned IdleWarning beforehand.
+	SessionIdleTimeout time.Duration `yaml:"sessionIdleTimeout"`
+	IdleWarning        time.Duration `yaml:"idleWarning"`
+	// MaxSessionLifetime closes sessions this long after they start, idle
+	// or not (0 means never)
+	MaxSessionLifetime time.Duration `yaml:"maxSessionLifetime"`
+	// Auth turns on basic auth and the /admin pages
+	Auth AuthOptions `yaml:"auth"`
+	// AuditLog is a file that gets a JSON line for every session start and
+	// end, menu command, spectator and admin action (empty means off)
+	AuditLog string `yaml:"auditLog"`
+	// AccessLog is a file that gets every HTTP request in the Combined Log
+	// Format (empty means off)
+	AccessLog string `yaml:"accessLog"`
+	// Commands are the marcli commands the HTTP API may run. They come from
+	// the command registry, not config.yml.
+	Commands []Command `yaml:"-"`
+	// Scripts are extra programs the HTTP API may run, defined like profiles
+	Scripts []Profile `yaml:"scripts"`
+	// JobsDir is where background jobs and their logs are kept (default
+	// ~/.marcli/jobs)
+	JobsDir string `yaml:"jobsDir"`
+	// MaxJobs is how many jobs may run at once (default 2)
+	MaxJobs int `yaml:"maxJobs"`
+	// JobHistory is how many finished jobs are kept (default 100)
+	JobHistory int `yaml:"jobHistory"`
+	// MediaDir is where files uploaded from the browser go and downloads
+	// come from (empty turns file transfer off)
+	MediaDir string `yaml:"mediaDir"`
+	// MediaQuota caps how much the media dir may hold, e.g. "50GB" (default
+	// 10GB)
+	MediaQuota ByteSize `yaml:"mediaQuota"`
+	// StartTerminal launches the program behind each session (default
+	// StartPTY). Tests swap in a fake terminal.
+	StartTerminal TerminalStarter `yaml:"-"`
+	// Profiles are the programs the browser may launch. With none configured
+	// the web terminal runs the cutiepie TUI.
+	Profiles []Profile `yaml:"profiles"`
+}
+
+// Default timeouts, used when Options leaves them at zero
+const (
+	defaultReadTimeout     = 30 * time.Second
+	defaultWriteTimeout    = 30 * time.Second
+	defaultIdleTimeout     = 2 * time.Minute
+	defaultShutdownTimeout = 10 * time.Second
+	defaultPingInterval    = 30 * time.Second
+	defaultPongTimeout     = 10 * time.Second
+	defaultIdleWarning     = time.Minute
 )
 
-// StartServer starts the HTTP server on the specified port
-func StartServer(port int) error {
-	// Create static file server
-	fs := http.FileServer(http.Dir("static"))
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
+// Server is the cutiepie-tty web terminal server
+type Server struct {
+	opts     Options
+	mux      *http.ServeMux
+	http     *http.Server
+	csrf     *csrfGuard
+	assets   *assetServer
+	sessions *sessionManager
+	metrics  *metrics
+	audit    *auditLog
+	access   *accessLog
+	jobs     *jobQueue
+	files    *mediaStore
+
+	mu       sync.Mutex
+	listener net.Listener
+	draining bool
+	conns    sync.WaitGroup
+	done     chan struct{}
+	err      error
+}
+
+// NewServer creates a server with its own mux and the given options
+func NewServer(opts Options) (*Server, error) {
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = defaultReadTimeout
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = defaultWriteTimeout
+	}
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = defaultIdleTimeout
+	}
+	if opts.ShutdownTimeout == 0 {
+		opts.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if opts.PingInterval == 0 {
+		opts.PingInterval = defaultPingInterval
+	}
+	if opts.PongTimeout == 0 {
+		opts.PongTimeout = defaultPongTimeout
+	}
+	if opts.IdleWarning == 0 {
+		opts.IdleWarning = defaultIdleWarning
+	}
+	if opts.StartTerminal == nil {
+		opts.StartTerminal = StartPTY
+	}
+
+	csrf, err := newCSRFGuard()
+	if err != nil {
+		return nil, err
+	}
+
+	// Serve the web UI, embedded unless a static dir override is set
+	assets, err := newAssetServer(opts.StaticDir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.StaticDir != "" {
+		logger.Info("Serving static files from disk", "dir", opts.StaticDir)
+	}
+
+	audit, err := openAuditLog(opts.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+	access, err := openAccessLog(opts.AccessLog)
+	if err != nil {
+		audit.Close()
+		return nil, err
+	}
+
+	jobs, err := newJobQueue(opts)
+	if err != nil {
+		audit.Close()
+		access.Close()
+		return nil, err
+	}
+
+	files, err := newMediaStore(opts.MediaDir, opts.MediaQuota)
+	if err != nil {
+		audit.Close()
+		access.Close()
+		jobs.stop()
+		return nil, err
+	}
+
+	metrics := newMetrics()
+	s := &Server{
+		opts:     opts,
+		mux:      http.NewServeMux(),
+		csrf:     csrf,
+		assets:   assets,
+		sessions: newSessionManager(metrics, audit),
+		metrics:  metrics,
+		audit:    audit,
+		access:   access,
+		jobs:     jobs,
+		files:    files,
+		done:     make(chan struct{}),
+	}
+	s.routes()
+
+	s.http = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: opts.ReadTimeout,
+		ReadTimeout:       opts.ReadTimeout,
+		WriteTimeout:      opts.WriteTimeout,
+		IdleTimeout:       opts.IdleTimeout,
+	}
+
+	return s, nil
+}
+
+// routes registers every handler on the server's mux
+func (s *Server) routes() {
+	s.mux.Handle("/static/", s.assets)
+
+	// Health checks and metrics are for load balancers and Prometheus, so
+	// they don't need a login
+	s.mux.HandleFunc("GET /healthz", s.handleHealthz)
+	s.mux.HandleFunc("GET /readyz", s.handleReadyz)
+	s.mux.HandleFunc("GET /metrics", s.handleMetrics)
 
-	// Serve index.html at root
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	// Everything else needs a login when users are configured
+	app := http.NewServeMux()
+	s.mux.Handle("/", s.requireUser(app))
+
+	// Serve index.html at root, with the CSRF token for this browser session
+	app.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
 			http.NotFound(w, r)
 			return
 		}
-		http.ServeFile(w, r, filepath.Join("static", "index.html"))
+		s.handleIndex(w, r)
 	})
 
 	// WebSocket endpoint for terminal I/O
-	http.HandleFunc("/ws", handleWebSocket)
+	app.HandleFunc("/ws", s.handleWebSocket)
+
+	// Read-only WebSocket endpoint for spectators with a share link
+	app.HandleFunc("/ws/watch", s.handleWatch)
+
+	// Admin pages and JSON API for keeping an eye on live sessions
+	app.HandleFunc("GET /admin/sessions", s.requireAdmin(s.handleAdminSessions))
+	app.HandleFunc("POST /admin/sessions/{id}/close", s.requireAdmin(s.handleAdminClosePost))
+	app.HandleFunc("GET /admin/api/sessions", s.requireAdmin(s.handleAdminListJSON))
+	app.HandleFunc("DELETE /admin/api/sessions/{id}", s.requireAdmin(s.handleAdminCloseJSON))
+
+	// JSON API for running commands without a terminal
+	app.HandleFunc("GET /api/commands", s.handleListCommands)
+	app.HandleFunc("POST /api/commands/{name}", s.handleRunCommand)
+
+	// Background jobs, and a page for keeping an eye on them
+	app.HandleFunc("GET /jobs", s.handleJobsPage)
+	app.HandleFunc("GET /api/jobs", s.handleListJobs)
+	app.HandleFunc("POST /api/jobs", s.handleSubmitJob)
+	app.HandleFunc("GET /api/jobs/{id}", s.handleGetJob)
+	app.HandleFunc("GET /api/jobs/{id}/log", s.handleJobLog)
+	app.HandleFunc("POST /api/jobs/{id}/cancel", s.handleCancelJob)
 
-	addr := fmt.Sprintf(":%d", port)
-	log.Printf("Starting server on %s", addr)
-	return http.ListenAndServe(addr, nil)
+	// File transfer to and from the media dir, with resumable uploads
+	app.HandleFunc("GET /api/files", s.handleListFiles)
+	app.HandleFunc("GET /files/{path...}", s.handleDownload)
+	app.HandleFunc("POST /api/uploads", s.handleCreateUpload)
+	app.HandleFunc("GET /api/uploads/{id}", s.handleGetUpload)
+	app.HandleFunc("PATCH /api/uploads/{id}", s.handleUploadChunk)
+	app.HandleFunc("DELETE /api/uploads/{id}", s.handleAbortUpload)
 }
 
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// Accept the WebSocket connection
-	conn, err := websocket.Accept(w, r, nil)
+// Handler returns the server's HTTP handler, e.g. for httptest. Background
+// jobs only run once the server is started.
+func (s *Server) Handler() http.Handler {
+	return s.logRequests(s.mux)
+}
+
+// Addr returns the address the server is listening on, once started
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Start starts listening and serves in the background. When ctx is cancelled
+// the server shuts down gracefully; Done is closed once it has stopped.
+func (s *Server) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", s.opts.Port))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+	logger.Info("Starting server", "addr", ln.Addr().String())
+
+	// Jobs left over from last time only run once we're actually serving
+	s.jobs.start()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.http.Serve(ln)
+	}()
+
+	go func() {
+		var err error
+		select {
+		case err = <-serveErr:
+			if err == http.ErrServerClosed {
+				err = nil
+			}
+		case <-ctx.Done():
+			logger.Info("Shutting down server...")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), s.opts.ShutdownTimeout)
+			err = s.Shutdown(shutdownCtx)
+			cancel()
+		}
+
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+		close(s.done)
+	}()
+
+	return nil
+}
+
+// Done is closed once a started server has stopped
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the server stopped, once Done is closed
+func (s *Server) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Shutdown stops accepting connections, tells every connected browser the
+// server is going away, ends all sessions and waits for them to drain
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+
+	// Interrupt running jobs (they run again next start) and hang up on
+	// anyone following a job log, or the HTTP shutdown would wait for them
+	s.jobs.stop()
+
+	// Stop accepting new connections (hijacked WebSockets aren't tracked here)
+	err := s.http.Shutdown(ctx)
+
+	// Notify clients and end every session
+	s.sessions.closeAll("server shutting down")
+
+	// Wait for the WebSocket handlers and jobs to finish up
+	drained := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		s.jobs.wait(ctx)
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		logger.Info("All sessions drained")
+		// Nothing is left to log, so the log files can be closed
+		s.audit.Close()
+		s.access.Close()
+		s.files.Close()
+	case <-ctx.Done():
+		logger.Warn("Timed out waiting for sessions to drain")
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+
+	return err
+}
+
+// trackConn registers a long-lived connection so Shutdown can wait for it.
+// It returns false if the server is already shutting down.
+func (s *Server) trackConn() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return false
+	}
+	s.conns.Add(1)
+	return true
+}
+
+// indexData is what index.html gets rendered with
+type indexData struct {
+	CSRFToken string
+	// Profile is the profile the terminal connects with
+	Profile string
+	// Profiles are listed on the launcher when the browser hasn't picked one
+	Profiles []Profile
+	// ShowLauncher shows the profile launcher instead of a terminal
+	ShowLauncher bool
+	// Files shows the file browser for uploads and downloads
+	Files bool
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := s.assets.page("index.html")
 	if err != nil {
-		log.Printf("Failed to accept WebSocket connection: %v", err)
+		logger.Error("Failed to load index.html", "err", err)
+		http.Error(w, "failed to load page", http.StatusInternalServerError)
 		return
 	}
-	defer conn.CloseNow()
 
-	log.Printf("WebSocket connection established")
+	sessionID, err := s.csrf.ensureSession(w, r)
+	if err != nil {
+		logger.Error("Failed to create session cookie", "err", err)
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	// The page carries a per-session token, so it must never be cached
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	data := indexData{
+		CSRFToken: s.csrf.token(sessionID),
+		Profiles:  s.opts.profiles(),
+		Files:     s.files != nil,
+	}
+
+	// With several profiles the browser picks one from the launcher first;
+	// spectators skip it since they join an existing session
+	query := r.URL.Query()
+	if name := query.Get("profile"); name != "" {
+		if _, ok := s.opts.profile(name); !ok {
+			http.Error(w, "unknown profile", http.StatusNotFound)
+			return
+		}
+		data.Profile = name
+	} else if len(data.Profiles) > 1 && query.Get("watch") == "" {
+		data.ShowLauncher = true
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		logger.Error("Failed to render index.html", "err", err)
+	}
+}
+
+// acceptWebSocket checks the origin and CSRF token and upgrades the connection
+func (s *Server) acceptWebSocket(w http.ResponseWriter, r *http.Request) (*websocket.Conn, bool) {
+	// Refuse cross-origin upgrades before anything else so a malicious page
+	// can't drive the terminal
+	if origin, ok := checkOrigin(r, s.opts.AllowedOrigins); !ok {
+		logger.Warn("Rejected cross-origin WebSocket upgrade", "remote", r.RemoteAddr, "origin", origin)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil, false
+	}
+
+	// The upgrade must carry the CSRF token tied to the session cookie
+	if err := s.csrf.validate(r); err != nil {
+		logger.Warn("Rejected WebSocket upgrade", "remote", r.RemoteAddr, "err", err)
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return nil, false
+	}
+
+	// WebSockets live far longer than the HTTP read/write timeouts, so clear
+	// the deadlines before the connection is hijacked
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	// Accept the WebSocket connection
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		OriginPatterns: s.opts.AllowedOrigins,
+	})
+	if err != nil {
+		s.metrics.websocketErrors.Add(1)
+		logger.Error("Failed to accept WebSocket connection", "remote", r.RemoteAddr, "err", err)
+		return nil, false
+	}
+	return conn, true
+}
 
-	// Close existing PTY and create new one
-	ptyMutex.Lock()
-	if currentPTY != nil {
-		currentPTY.Close()
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	profile, ok := s.opts.profile(r.URL.Query().Get("profile"))
+	if !ok {
+		logger.Warn("Rejected WebSocket upgrade for unknown profile", "remote", r.RemoteAddr, "profile", r.URL.Query().Get("profile"))
+		http.Error(w, "unknown profile", http.StatusNotFound)
+		return
 	}
-	currentPTY = NewPTYManager()
-	if err := currentPTY.Start(); err != nil {
-		ptyMutex.Unlock()
-		log.Printf("Failed to start PTY: %v", err)
+
+	if !s.trackConn() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
 		return
 	}
-	log.Printf("PTY started successfully")
+	defer s.conns.Done()
 
-	// Set initial terminal size - use a reasonable default that matches common browser sizes
-	// We'll resize it properly once the client sends the actual terminal size
-	// For now, use a larger default to prevent overflow
-	if err := currentPTY.Resize(120, 30); err != nil {
-		log.Printf("Failed to resize PTY: %v", err)
+	conn, ok := s.acceptWebSocket(w, r)
+	if !ok {
+		return
 	}
-	ptyMutex.Unlock()
+	defer conn.CloseNow()
+
+	logger.Debug("WebSocket connection established", "remote", r.RemoteAddr)
+
+	// Every owner connection gets its own session, running the profile the
+	// browser picked from the launcher
+	sess, err := s.sessions.start(clientFrom(r), s.opts, profile)
+	if err != nil {
+		logger.Error("Failed to start session", "remote", r.RemoteAddr, "profile", profile.Name, "err", err)
+		conn.Close(websocket.StatusInternalError, "failed to start terminal")
+		return
+	}
+	logger.Info("Session started", "session", sess.ID, "user", sess.User, "remote", sess.RemoteAddr, "command", sess.Command)
 
 	// Clean up on exit
 	defer func() {
-		log.Printf("WebSocket connection closing, cleaning up PTY")
-		ptyMutex.Lock()
-		if currentPTY != nil {
-			currentPTY.Close()
-			currentPTY = nil
-		}
-		ptyMutex.Unlock()
+		logger.Info("Session ended", "session", sess.ID, "bytesIn", sess.bytesIn.Load(), "bytesOut", sess.bytesOut.Load())
+		s.sessions.remove(sess, "session ended")
 	}()
 
-	// Channel to signal when copying is done
-	done := make(chan bool, 2)
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	owner := newSubscriber()
+	sess.attachOwner(owner)
 
-	// Copy from PTY to WebSocket (terminal output -> browser)
+	// Copy from PTY to subscribers (terminal output -> browsers)
+	go sess.pump()
+
+	// Drop half-open connections, and end idle or expired sessions
+	go s.keepalive(ctx, conn, cancel)
+	go sess.expire(ctx, s.opts)
+
+	// Copy queued output to this WebSocket
 	go func() {
-		buf := make([]byte, 32*1024)
-		for {
-			n, err := currentPTY.Read(buf)
-			if n > 0 {
-				if writeErr := conn.Write(ctx, websocket.MessageText, buf[:n]); writeErr != nil {
-					log.Printf("Error writing to WebSocket: %v", writeErr)
-					done <- true
-					return
-				}
-			}
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("Error reading from PTY: %v", err)
-				} else {
-					log.Printf("PTY closed (EOF)")
-				}
-				done <- true
-				return
-			}
+		if err := owner.writeLoop(ctx, conn); err != nil && ctx.Err() == nil {
+			s.metrics.websocketErrors.Add(1)
+			logger.Error("Error writing to WebSocket", "session", sess.ID, "err", err)
 		}
+		cancel()
 	}()
 
 	// Copy from WebSocket to PTY (browser input -> terminal)
-	go func() {
-		for {
-			typ, buf, err := conn.Read(ctx)
-			if len(buf) > 0 {
-				if _, writeErr := currentPTY.Write(buf); writeErr != nil {
-					log.Printf("Error writing to PTY: %v", writeErr)
-					done <- true
-					return
-				}
-			}
-			if err != nil {
-				// Check if it's a close error
-				if err == io.EOF || websocket.CloseStatus(err) != -1 {
-					log.Printf("WebSocket closed")
-				} else {
-					log.Printf("Error reading from WebSocket: %v", err)
-				}
-				done <- true
-				return
+	for {
+		typ, buf, err := conn.Read(ctx)
+		if err != nil {
+			// Check if it's a close error
+			if err == io.EOF || websocket.CloseStatus(err) != -1 || ctx.Err() != nil {
+				logger.Debug("WebSocket closed", "session", sess.ID)
+			} else {
+				s.metrics.websocketErrors.Add(1)
+				logger.Error("Error reading from WebSocket", "session", sess.ID, "err", err)
 			}
-			_ = typ // Message type (binary/text), we handle both
+			return
+		}
+		if typ == websocket.MessageText {
+			s.handleControlMessage(sess, owner, buf)
+			continue
 		}
+		if _, err := sess.Write(buf); err != nil {
+			logger.Error("Error writing to PTY", "session", sess.ID, "err", err)
+			return
+		}
+	}
+}
+
+// handleWatch serves a read-only spectator connection for a shared session
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	sess := s.sessions.byShareToken(r.URL.Query().Get("token"))
+	if sess == nil {
+		http.Error(w, "share link not found or revoked", http.StatusNotFound)
+		return
+	}
+
+	if !s.trackConn() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.conns.Done()
+
+	conn, ok := s.acceptWebSocket(w, r)
+	if !ok {
+		return
+	}
+	defer conn.CloseNow()
+
+	viewer := newSubscriber()
+	if err := sess.addViewer(viewer); err != nil {
+		conn.Close(websocket.StatusNormalClosure, err.Error())
+		return
+	}
+	defer sess.removeViewer(viewer)
+	logger.Info("Spectator joined", "session", sess.ID, "remote", r.RemoteAddr)
+	s.audit.record(AuditEvent{
+		Event:      auditSpectatorJoin,
+		SessionID:  sess.ID,
+		RemoteAddr: r.RemoteAddr,
+		User:       userFrom(r),
+		UserAgent:  r.UserAgent(),
+	})
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	go func() {
+		viewer.writeLoop(ctx, conn)
+		cancel()
 	}()
+	go s.keepalive(ctx, conn, cancel)
 
-	// Wait for either copy to finish
-	<-done
-	log.Printf("One of the copy operations finished, closing WebSocket")
+	// Spectators are read-only, so anything they send is dropped
+	for {
+		if _, _, err := conn.Read(ctx); err != nil {
+			logger.Info("Spectator left", "session", sess.ID, "remote", r.RemoteAddr)
+			s.audit.record(AuditEvent{
+				Event:      auditSpectatorLeave,
+				SessionID:  sess.ID,
+				RemoteAddr: r.RemoteAddr,
+				User:       userFrom(r),
+			})
+			return
+		}
+	}
+}
+
+// keepalive pings the WebSocket every PingInterval and cancels the
+// connection if a pong doesn't come back within PongTimeout, so a half-open
+// TCP connection can't keep a session alive forever
+func (s *Server) keepalive(ctx context.Context, conn *websocket.Conn, cancel context.CancelFunc) {
+	ticker := time.NewTicker(s.opts.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, pingCancel := context.WithTimeout(ctx, s.opts.PongTimeout)
+		err := conn.Ping(pingCtx)
+		pingCancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				s.metrics.websocketErrors.Add(1)
+				logger.Warn("WebSocket didn't answer ping, closing", "err", err)
+			}
+			cancel()
+			return
+		}
+	}
+}
+
+// handleControlMessage applies a JSON control message from the session owner
+func (s *Server) handleControlMessage(sess *Session, owner *subscriber, data []byte) {
+	msg, err := parseControlMessage(data)
+	if err != nil {
+		logger.Warn("Ignoring control message", "session", sess.ID, "err", err)
+		return
+	}
+
+	switch msg.Type {
+	case msgResize:
+		if msg.Cols == 0 || msg.Rows == 0 {
+			return
+		}
+		if err := sess.Resize(msg.Cols, msg.Rows); err != nil {
+			logger.Error("Failed to resize PTY", "err", err)
+		}
+	case msgShare:
+		token, err := s.sessions.share(sess)
+		if err != nil {
+			logger.Error("Failed to share session", "session", sess.ID, "err", err)
+			return
+		}
+		logger.Info("Session shared for spectators", "session", sess.ID)
+		owner.trySend(wsMessage{websocket.MessageText, encodeControlMessage(controlMessage{Type: msgShared, Token: token})})
+		sess.broadcastViewerCount()
+	case msgUnshare:
+		s.sessions.unshare(sess)
+		logger.Info("Session share link revoked", "session", sess.ID)
+	default:
+		logger.Warn("Ignoring unknown control message", "session", sess.ID, "type", msg.Type)
+	}
 }
@@ -0,0 +1,714 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/charmbracelet/log"
+	"github.com/coder/websocket"
+)
+
+const (
+	// scrollbackSize is how much recent output a spectator gets on joining
+	scrollbackSize = 64 * 1024
+	// subscriberBuffer is how many messages can queue up for one socket
+	subscriberBuffer = 256
+	// defaultCols and defaultRows are the PTY size until the browser reports its own
+	defaultCols = 120
+	defaultRows = 30
+	// exitWaitTimeout is how long to wait for the program to be reaped after
+	// its output closes
+	exitWaitTimeout = 2 * time.Second
+)
+
+// wsMessage is a message queued for a WebSocket
+type wsMessage struct {
+	typ  websocket.MessageType
+	data []byte
+}
+
+// subscriber is one WebSocket receiving a session's output
+type subscriber struct {
+	out    chan wsMessage
+	done   chan struct{}
+	once   sync.Once
+	code   websocket.StatusCode
+	reason string
+}
+
+func newSubscriber() *subscriber {
+	return &subscriber{
+		out:  make(chan wsMessage, subscriberBuffer),
+		done: make(chan struct{}),
+	}
+}
+
+// close disconnects the subscriber with a reason shown to the browser
+func (s *subscriber) close(reason string) {
+	s.closeWithStatus(websocket.StatusNormalClosure, reason)
+}
+
+// closeWithStatus disconnects the subscriber with a specific close code
+func (s *subscriber) closeWithStatus(code websocket.StatusCode, reason string) {
+	s.once.Do(func() {
+		s.code = code
+		s.reason = reason
+		close(s.done)
+	})
+}
+
+// send queues a message, waiting for room. Returns false once closed.
+func (s *subscriber) send(msg wsMessage) bool {
+	select {
+	case s.out <- msg:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// trySend queues a message without waiting. Returns false if the subscriber
+// is closed or can't keep up.
+func (s *subscriber) trySend(msg wsMessage) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	select {
+	case s.out <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeLoop copies queued messages to the WebSocket until the subscriber is
+// closed or a write fails
+func (s *subscriber) writeLoop(ctx context.Context, conn *websocket.Conn) error {
+	for {
+		select {
+		case msg := <-s.out:
+			if err := conn.Write(ctx, msg.typ, msg.data); err != nil {
+				return err
+			}
+		case <-s.done:
+			// Flush whatever is still queued (like the last lines of output)
+			for {
+				select {
+				case msg := <-s.out:
+					if err := conn.Write(ctx, msg.typ, msg.data); err != nil {
+						return err
+					}
+				default:
+					return conn.Close(s.code, s.reason)
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// clientInfo describes who opened a connection
+type clientInfo struct {
+	RemoteAddr string
+	// User is who logged in ("" with auth off)
+	User      string
+	UserAgent string
+}
+
+// clientFrom reads the client details from a request
+func clientFrom(r *http.Request) clientInfo {
+	return clientInfo{
+		RemoteAddr: r.RemoteAddr,
+		User:       userFrom(r),
+		UserAgent:  r.UserAgent(),
+	}
+}
+
+// Session is a running terminal with a single owner who can type into it and
+// any number of read-only spectators watching through a share link
+type Session struct {
+	ID string
+	// User is who logged in to start the session ("" with auth off)
+	User       string
+	RemoteAddr string
+	UserAgent  string
+	Started    time.Time
+	Profile    string
+	Command    string
+
+	term    Terminal
+	rec     *Recorder
+	metrics *metrics
+	audit   *auditLog
+
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+
+	mu         sync.Mutex
+	owner      *subscriber
+	viewers    map[*subscriber]struct{}
+	shareToken string
+	cols, rows uint16
+	scrollback []byte
+	closed     bool
+	exit       *ExitStatus
+	lastInput  time.Time
+	// closeReason is why the session ended, for the audit log
+	closeReason string
+}
+
+// sessionManager keeps track of live sessions and their share links
+type sessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	shares   map[string]*Session
+	metrics  *metrics
+	audit    *auditLog
+}
+
+func newSessionManager(metrics *metrics, audit *auditLog) *sessionManager {
+	return &sessionManager{
+		sessions: make(map[string]*Session),
+		shares:   make(map[string]*Session),
+		metrics:  metrics,
+		audit:    audit,
+	}
+}
+
+// start launches a new terminal session running a profile for an owner connection
+func (m *sessionManager) start(client clientInfo, opts Options, profile Profile) (*Session, error) {
+	id, err := randomID(8)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := profile.Spec()
+	if err != nil {
+		return nil, err
+	}
+	spec.GracePeriod = opts.KillGracePeriod
+	spec.Env = append(spec.Env, SessionEnv+"="+id)
+
+	// The TUI reports the menu commands it runs through a pipe of its own
+	var commands *os.File
+	if profile.runsTUI() {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		commands, spec.CommandPipe = r, w
+	}
+
+	term, err := opts.StartTerminal(spec)
+	if err != nil {
+		if commands != nil {
+			commands.Close()
+			spec.CommandPipe.Close()
+		}
+		m.metrics.ptyStartFailures.Add(1)
+		return nil, fmt.Errorf("failed to start PTY: %w", err)
+	}
+	m.metrics.sessionsTotal.Add(1)
+
+	// Use a reasonable default size until the browser sends its real size
+	if err := term.Resize(defaultCols, defaultRows); err != nil {
+		logger.Error("Failed to resize PTY", "err", err)
+	}
+
+	s := &Session{
+		ID:         id,
+		User:       client.User,
+		RemoteAddr: client.RemoteAddr,
+		UserAgent:  client.UserAgent,
+		Started:    time.Now(),
+		lastInput:  time.Now(),
+		Profile:    profile.Name,
+		Command:    spec.String(),
+		term:       term,
+		metrics:    m.metrics,
+		audit:      m.audit,
+		viewers:    make(map[*subscriber]struct{}),
+		cols:       defaultCols,
+		rows:       defaultRows,
+	}
+
+	// Record the session if asked to
+	if opts.Record {
+		dir := opts.RecordingsDir
+		if dir == "" {
+			dir = DefaultRecordingsDir()
+		}
+		rec, err := NewRecorder(dir, fmt.Sprintf("cutiepie-tty %s (%s)", client.RemoteAddr, profile.Name), defaultCols, defaultRows)
+		if err != nil {
+			logger.Error("Failed to start recording", "err", err)
+		} else {
+			logger.Info("Recording session", "session", id, "path", rec.Path())
+			s.rec = rec
+		}
+	}
+
+	m.mu.Lock()
+	m.sessions[id] = s
+	m.mu.Unlock()
+
+	m.audit.record(AuditEvent{
+		Event:      auditSessionStart,
+		SessionID:  id,
+		RemoteAddr: s.RemoteAddr,
+		User:       s.User,
+		UserAgent:  s.UserAgent,
+		Profile:    s.Profile,
+		Command:    s.Command,
+	})
+	if commands != nil {
+		go s.readCommands(commands)
+	}
+
+	return s, nil
+}
+
+// remove closes a session and forgets about it
+func (m *sessionManager) remove(s *Session, reason string) {
+	m.mu.Lock()
+	_, live := m.sessions[s.ID]
+	delete(m.sessions, s.ID)
+	for token, shared := range m.shares {
+		if shared == s {
+			delete(m.shares, token)
+		}
+	}
+	m.mu.Unlock()
+
+	s.Close(reason)
+	if !live {
+		return
+	}
+
+	duration := time.Since(s.Started)
+	m.metrics.observeSessionDuration(duration)
+	m.audit.record(AuditEvent{
+		Event:      auditSessionEnd,
+		SessionID:  s.ID,
+		RemoteAddr: s.RemoteAddr,
+		User:       s.User,
+		UserAgent:  s.UserAgent,
+		Profile:    s.Profile,
+		Reason:     s.CloseReason(),
+		Duration:   duration.Seconds(),
+		BytesIn:    s.bytesIn.Load(),
+		BytesOut:   s.bytesOut.Load(),
+		Exit:       s.ExitStatus(),
+	})
+}
+
+// get finds a live session by id
+func (m *sessionManager) get(id string) *Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[id]
+}
+
+// list returns every live session, oldest first
+func (m *sessionManager) list() []*Session {
+	m.mu.Lock()
+	all := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		all = append(all, s)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Started.Before(all[j].Started)
+	})
+	return all
+}
+
+// closeAll tells every connected browser the server is going away and ends
+// all sessions
+func (m *sessionManager) closeAll(reason string) {
+	m.mu.Lock()
+	all := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		all = append(all, s)
+	}
+	m.mu.Unlock()
+
+	// Programs get a grace period to exit, so stop them all at once
+	var wg sync.WaitGroup
+	for _, s := range all {
+		wg.Add(1)
+		go func(s *Session) {
+			defer wg.Done()
+			s.broadcastControl(controlMessage{Type: msgShutdown}, true)
+			s.closeWithStatus(websocket.StatusGoingAway, reason)
+		}(s)
+	}
+	wg.Wait()
+}
+
+// byShareToken finds the session a share link points to
+func (m *sessionManager) byShareToken(token string) *Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.shares[token]
+}
+
+// share creates (or returns the existing) share token for a session
+func (m *sessionManager) share(s *Session) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shareToken != "" {
+		return s.shareToken, nil
+	}
+
+	token, err := randomID(16)
+	if err != nil {
+		return "", err
+	}
+	s.shareToken = token
+
+	m.mu.Lock()
+	m.shares[token] = s
+	m.mu.Unlock()
+
+	return token, nil
+}
+
+// unshare revokes a session's share link and disconnects its spectators
+func (m *sessionManager) unshare(s *Session) {
+	s.mu.Lock()
+	token := s.shareToken
+	s.shareToken = ""
+	viewers := s.viewers
+	s.viewers = make(map[*subscriber]struct{})
+	s.mu.Unlock()
+
+	if token != "" {
+		m.mu.Lock()
+		delete(m.shares, token)
+		m.mu.Unlock()
+	}
+
+	for v := range viewers {
+		v.close("share link revoked")
+	}
+	s.broadcastViewerCount()
+}
+
+// attachOwner sets the subscriber that receives output and may type
+func (s *Session) attachOwner(sub *subscriber) {
+	s.mu.Lock()
+	s.owner = sub
+	s.mu.Unlock()
+}
+
+// addViewer adds a read-only spectator, catching them up with the current
+// terminal size and recent output
+func (s *Session) addViewer(sub *subscriber) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return fmt.Errorf("session has ended")
+	}
+	s.viewers[sub] = struct{}{}
+	size := encodeControlMessage(controlMessage{Type: msgSize, Cols: s.cols, Rows: s.rows})
+	backlog := append([]byte(nil), s.scrollback...)
+	s.mu.Unlock()
+
+	sub.trySend(wsMessage{websocket.MessageText, size})
+	if len(backlog) > 0 {
+		sub.trySend(wsMessage{websocket.MessageBinary, backlog})
+	}
+	s.broadcastViewerCount()
+	return nil
+}
+
+// removeViewer drops a spectator
+func (s *Session) removeViewer(sub *subscriber) {
+	s.mu.Lock()
+	_, ok := s.viewers[sub]
+	delete(s.viewers, sub)
+	s.mu.Unlock()
+
+	sub.close("")
+	if ok {
+		s.broadcastViewerCount()
+	}
+}
+
+// ViewerCount returns how many spectators are watching
+func (s *Session) ViewerCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.viewers)
+}
+
+// Write sends input from the owner to the terminal
+func (s *Session) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	s.lastInput = time.Now()
+	s.mu.Unlock()
+
+	n, err := s.term.Write(data)
+	s.bytesIn.Add(int64(n))
+	s.metrics.bytesIn.Add(int64(n))
+	return n, err
+}
+
+// LastInput returns when the owner last typed something (or when the
+// session started, if they haven't yet)
+func (s *Session) LastInput() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastInput
+}
+
+// expire ends the session once it has been idle for too long or has hit its
+// maximum lifetime, warning everyone shortly before. It returns when either
+// happens or ctx is done.
+func (s *Session) expire(ctx context.Context, opts Options) {
+	if opts.SessionIdleTimeout <= 0 && opts.MaxSessionLifetime <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	idleWarned, lifetimeWarned := false, false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if opts.MaxSessionLifetime > 0 {
+			left := time.Until(s.Started.Add(opts.MaxSessionLifetime))
+			if left <= 0 {
+				logger.Info("Session reached its maximum lifetime", "session", s.ID)
+				s.closeWithStatus(statusMaxLifetime, "session reached its maximum lifetime")
+				return
+			}
+			if left <= warningWindow(opts.MaxSessionLifetime, opts.IdleWarning) && !lifetimeWarned {
+				lifetimeWarned = true
+				s.warn("lifetime", left)
+			}
+		}
+
+		if opts.SessionIdleTimeout > 0 {
+			left := opts.SessionIdleTimeout - time.Since(s.LastInput())
+			if left <= 0 {
+				logger.Info("Session timed out", "session", s.ID, "idle", opts.SessionIdleTimeout)
+				s.closeWithStatus(statusIdleTimeout, "idle timeout")
+				return
+			}
+			if left <= warningWindow(opts.SessionIdleTimeout, opts.IdleWarning) {
+				if !idleWarned {
+					idleWarned = true
+					s.warn("idle", left)
+				}
+			} else {
+				// They typed something since the warning
+				idleWarned = false
+			}
+		}
+	}
+}
+
+// warningWindow is how long before a limit to warn: the configured warning,
+// but never more than half the limit itself
+func warningWindow(limit, warning time.Duration) time.Duration {
+	return min(warning, limit/2)
+}
+
+// warn tells everyone the session will be closed soon
+func (s *Session) warn(reason string, left time.Duration) {
+	seconds := int(left.Round(time.Second) / time.Second)
+	s.broadcastControl(controlMessage{Type: msgWarning, Reason: reason, Seconds: seconds}, true)
+}
+
+// Resize resizes the terminal and tells spectators about the new size
+func (s *Session) Resize(cols, rows uint16) error {
+	if err := s.term.Resize(cols, rows); err != nil {
+		return err
+	}
+	if s.rec != nil {
+		s.rec.Resize(cols, rows)
+	}
+
+	s.mu.Lock()
+	s.cols, s.rows = cols, rows
+	s.mu.Unlock()
+
+	s.broadcastControl(controlMessage{Type: msgSize, Cols: cols, Rows: rows}, false)
+	return nil
+}
+
+// pump reads terminal output and fans it out until the terminal closes. The
+// owner gets every byte (and applies backpressure); spectators that can't
+// keep up are disconnected rather than slowing everyone down.
+func (s *Session) pump() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := s.term.Read(buf)
+		if n > 0 {
+			data := append([]byte(nil), buf[:n]...)
+			s.bytesOut.Add(int64(n))
+			s.metrics.bytesOut.Add(int64(n))
+			if s.rec != nil {
+				s.rec.Output(data)
+			}
+			s.broadcastOutput(data)
+		}
+		if err != nil {
+			if err != io.EOF {
+				logger.Error("Error reading from PTY", "session", s.ID, "err", err)
+			} else {
+				logger.Debug("PTY closed", "session", s.ID)
+			}
+			s.finish()
+			return
+		}
+	}
+}
+
+// finish ends the session once the terminal's output has run dry. If the
+// program exited on its own, everyone is told its exit status first so the
+// browser can offer a restart instead of reconnecting blindly.
+func (s *Session) finish() {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return
+	}
+
+	// The output closes as the program exits; give it a moment to be reaped
+	exited := make(chan struct{})
+	go func() {
+		s.term.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(exitWaitTimeout):
+		s.Close("session ended")
+		return
+	}
+
+	status := exitStatusOf(s.term)
+	s.mu.Lock()
+	s.exit = &status
+	s.mu.Unlock()
+	logger.Info("Session process exited", "session", s.ID, "code", status.Code, "signal", status.Signal)
+
+	s.broadcastControl(controlMessage{Type: msgExited, Exit: &status}, true)
+	s.Close("process exited")
+}
+
+// CloseReason returns why the session ended, once it has
+func (s *Session) CloseReason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeReason
+}
+
+// ExitStatus returns how the session's program ended, or nil if it's still
+// running (or was stopped by the server)
+func (s *Session) ExitStatus() *ExitStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exit
+}
+
+func (s *Session) broadcastOutput(data []byte) {
+	s.mu.Lock()
+	s.scrollback = append(s.scrollback, data...)
+	if len(s.scrollback) > scrollbackSize {
+		s.scrollback = append([]byte(nil), s.scrollback[len(s.scrollback)-scrollbackSize:]...)
+	}
+	owner := s.owner
+	viewers := make([]*subscriber, 0, len(s.viewers))
+	for v := range s.viewers {
+		viewers = append(viewers, v)
+	}
+	s.mu.Unlock()
+
+	msg := wsMessage{websocket.MessageBinary, data}
+	if owner != nil {
+		owner.send(msg)
+	}
+	for _, v := range viewers {
+		if !v.trySend(msg) {
+			logger.Warn("Dropping spectator that is too slow", "session", s.ID)
+			s.removeViewer(v)
+		}
+	}
+}
+
+// broadcastControl sends a control message to spectators, and to the owner
+// too if includeOwner is set
+func (s *Session) broadcastControl(msg controlMessage, includeOwner bool) {
+	data := encodeControlMessage(msg)
+
+	s.mu.Lock()
+	targets := make([]*subscriber, 0, len(s.viewers)+1)
+	if includeOwner && s.owner != nil {
+		targets = append(targets, s.owner)
+	}
+	for v := range s.viewers {
+		targets = append(targets, v)
+	}
+	s.mu.Unlock()
+
+	for _, t := range targets {
+		t.trySend(wsMessage{websocket.MessageText, data})
+	}
+}
+
+func (s *Session) broadcastViewerCount() {
+	s.broadcastControl(controlMessage{Type: msgViewers, Count: s.ViewerCount()}, true)
+}
+
+// Close stops the terminal and disconnects everyone
+func (s *Session) Close(reason string) {
+	s.closeWithStatus(websocket.StatusNormalClosure, reason)
+}
+
+func (s *Session) closeWithStatus(code websocket.StatusCode, reason string) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.closeReason = reason
+	owner := s.owner
+	viewers := s.viewers
+	s.viewers = make(map[*subscriber]struct{})
+	s.mu.Unlock()
+
+	// Disconnect everyone first - the program may take a while to exit
+	if owner != nil {
+		owner.closeWithStatus(code, reason)
+	}
+	for v := range viewers {
+		v.closeWithStatus(code, reason)
+	}
+
+	s.term.Close()
+	if s.rec != nil {
+		s.rec.Close()
+	}
+}
@@ -0,0 +1,390 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// testTimeout bounds every wait in these tests
+const testTimeout = 5 * time.Second
+
+// newTestServer starts a server whose sessions run fake terminals, unless
+// opts brings its own
+func newTestServer(t *testing.T, opts Options) (*Server, *httptest.Server, <-chan *FakeTerminal) {
+	t.Helper()
+	starter, started := FakeStarter()
+	if opts.StartTerminal == nil {
+		opts.StartTerminal = starter
+	}
+	if opts.JobsDir == "" {
+		opts.JobsDir = t.TempDir()
+	}
+	s, err := NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return s, ts, started
+}
+
+// dial opens a WebSocket like the page does: load it for the session cookie,
+// then upgrade with the CSRF token
+func dial(t *testing.T, s *Server, ts *httptest.Server, path string) *websocket.Conn {
+	t.Helper()
+	return dialAs(t, s, ts, path, "", "")
+}
+
+// dialAs is dial for a logged in user
+func dialAs(t *testing.T, s *Server, ts *httptest.Server, path, user, password string) *websocket.Conn {
+	t.Helper()
+	req, _ := http.NewRequest("GET", ts.URL+"/", nil)
+	header := http.Header{}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+		header.Set("Authorization", req.Header.Get("Authorization"))
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	cookie := resp.Cookies()[0]
+	header.Set("Cookie", cookie.Name+"="+cookie.Value)
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + path + sep + "csrf=" + s.csrf.token(cookie.Value)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+		HTTPHeader: header,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+// startSession opens an owner connection and returns the terminal behind it
+func startSession(t *testing.T, s *Server, ts *httptest.Server, started <-chan *FakeTerminal) (*websocket.Conn, *FakeTerminal) {
+	t.Helper()
+	conn := dial(t, s, ts, "/ws")
+	select {
+	case f := <-started:
+		t.Cleanup(func() { f.Exit(0) })
+		return conn, f
+	case <-time.After(testTimeout):
+		t.Fatal("no terminal was started")
+		return nil, nil
+	}
+}
+
+// readOutput reads until the terminal output seen so far contains want,
+// skipping control messages
+func readOutput(t *testing.T, conn *websocket.Conn, want string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	var got strings.Builder
+	for !strings.Contains(got.String(), want) {
+		typ, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("waiting for output %q (got %q): %v", want, got.String(), err)
+		}
+		if typ == websocket.MessageBinary {
+			got.Write(data)
+		}
+	}
+}
+
+// readControl reads until a control message of the given type arrives,
+// skipping everything else
+func readControl(t *testing.T, conn *websocket.Conn, typ string) controlMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	for {
+		msgType, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("waiting for %q message: %v", typ, err)
+		}
+		if msgType != websocket.MessageText {
+			continue
+		}
+		msg, err := parseControlMessage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+// readClose reads until the server closes the connection and returns the
+// close code and reason
+func readClose(t *testing.T, conn *websocket.Conn) (websocket.StatusCode, string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	for {
+		if _, _, err := conn.Read(ctx); err != nil {
+			var closeErr websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("connection ended without a close frame: %v", err)
+			}
+			return closeErr.Code, closeErr.Reason
+		}
+	}
+}
+
+// sendControl sends a control message as the browser
+func sendControl(t *testing.T, conn *websocket.Conn, msg controlMessage) {
+	t.Helper()
+	data, _ := json.Marshal(msg)
+	if err := conn.Write(context.Background(), websocket.MessageText, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readInput reads n bytes the session typed into the terminal
+func readInput(t *testing.T, f *FakeTerminal, n int) string {
+	t.Helper()
+	got := make(chan string, 1)
+	go func() {
+		buf := make([]byte, n)
+		io.ReadFull(f.Input(), buf)
+		got <- string(buf)
+	}()
+	select {
+	case s := <-got:
+		return s
+	case <-time.After(testTimeout):
+		t.Fatalf("no input reached the terminal")
+		return ""
+	}
+}
+
+func TestSessionInputOutput(t *testing.T) {
+	s, ts, started := newTestServer(t, Options{})
+	conn, f := startSession(t, s, ts, started)
+
+	if err := f.Emit([]byte("hello from the program")); err != nil {
+		t.Fatal(err)
+	}
+	readOutput(t, conn, "hello from the program")
+
+	if err := conn.Write(context.Background(), websocket.MessageBinary, []byte("ls\r")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readInput(t, f, 3); got != "ls\r" {
+		t.Errorf("terminal got input %q, want %q", got, "ls\r")
+	}
+
+	// The program runs with the session's ID so the TUI can tag its output
+	if !strings.Contains(strings.Join(f.Spec.Env, "\n"), SessionEnv+"=") {
+		t.Errorf("terminal env %q has no %s", f.Spec.Env, SessionEnv)
+	}
+}
+
+func TestSessionResize(t *testing.T) {
+	s, ts, started := newTestServer(t, Options{})
+	conn, f := startSession(t, s, ts, started)
+
+	sendControl(t, conn, controlMessage{Type: msgResize, Cols: 0, Rows: 40})
+	sendControl(t, conn, controlMessage{Type: msgResize, Cols: 100, Rows: 40})
+	if err := conn.Write(context.Background(), websocket.MessageText, []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Control messages are handled in order, so once the next one is in
+	// the resizes have been applied
+	sendControl(t, conn, controlMessage{Type: msgShare})
+	readControl(t, conn, msgShared)
+
+	want := [][2]uint16{{defaultCols, defaultRows}, {100, 40}}
+	got := f.Resizes()
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("resizes = %v, want %v (a zero size is ignored)", got, want)
+	}
+}
+
+func TestSessionExit(t *testing.T) {
+	tests := []struct {
+		name string
+		end  func(f *FakeTerminal)
+		want ExitStatus
+	}{
+		{"exit code", func(f *FakeTerminal) { f.Exit(3) }, ExitStatus{Code: 3}},
+		{"signal", func(f *FakeTerminal) { f.Kill("SIGTERM") }, ExitStatus{Code: -1, Signal: "SIGTERM"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ts, started := newTestServer(t, Options{})
+			conn, f := startSession(t, s, ts, started)
+
+			tt.end(f)
+			msg := readControl(t, conn, msgExited)
+			if msg.Exit == nil || *msg.Exit != tt.want {
+				t.Errorf("exited with %+v, want %+v", msg.Exit, tt.want)
+			}
+			code, reason := readClose(t, conn)
+			if code != websocket.StatusNormalClosure || reason != "process exited" {
+				t.Errorf("closed with %d %q, want %d %q", code, reason, websocket.StatusNormalClosure, "process exited")
+			}
+		})
+	}
+}
+
+func TestSessionCloseCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		warning string
+		code    websocket.StatusCode
+	}{
+		{
+			name:    "idle timeout",
+			opts:    Options{SessionIdleTimeout: 2 * time.Second, IdleWarning: time.Second},
+			warning: "idle",
+			code:    statusIdleTimeout,
+		},
+		{
+			name:    "max lifetime",
+			opts:    Options{MaxSessionLifetime: 2 * time.Second, IdleWarning: time.Second},
+			warning: "lifetime",
+			code:    statusMaxLifetime,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, ts, started := newTestServer(t, tt.opts)
+			conn, f := startSession(t, s, ts, started)
+
+			if msg := readControl(t, conn, msgWarning); msg.Reason != tt.warning {
+				t.Errorf("warning reason = %q, want %q", msg.Reason, tt.warning)
+			}
+			if code, _ := readClose(t, conn); code != tt.code {
+				t.Errorf("close code = %d, want %d", code, tt.code)
+			}
+			select {
+			case <-f.Exited():
+			case <-time.After(testTimeout):
+				t.Error("the program was left running")
+			}
+		})
+	}
+}
+
+func TestSessionSpectators(t *testing.T) {
+	s, ts, started := newTestServer(t, Options{})
+	owner, f := startSession(t, s, ts, started)
+
+	// Output from before the spectator joins comes as scrollback
+	f.Emit([]byte("before joining "))
+	readOutput(t, owner, "before joining")
+	sendControl(t, owner, controlMessage{Type: msgResize, Cols: 90, Rows: 25})
+
+	sendControl(t, owner, controlMessage{Type: msgShare})
+	token := readControl(t, owner, msgShared).Token
+	if token == "" {
+		t.Fatal("share gave no token")
+	}
+
+	watcher := dial(t, s, ts, "/ws/watch?token="+token)
+	if size := readControl(t, watcher, msgSize); size.Cols != 90 || size.Rows != 25 {
+		t.Errorf("spectator got size %dx%d, want 90x25", size.Cols, size.Rows)
+	}
+	readOutput(t, watcher, "before joining")
+	for _, want := range []int{0, 1} {
+		if msg := readControl(t, owner, msgViewers); msg.Count != want {
+			t.Errorf("owner sees %d viewers, want %d", msg.Count, want)
+		}
+	}
+
+	// Spectators are read-only: only the owner's typing reaches the program
+	if err := watcher.Write(context.Background(), websocket.MessageBinary, []byte("sneaky")); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.Write(context.Background(), websocket.MessageBinary, []byte("owner")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readInput(t, f, 5); got != "owner" {
+		t.Errorf("terminal got input %q, want only the owner's", got)
+	}
+
+	// Live output fans out to everyone
+	f.Emit([]byte("after joining"))
+	readOutput(t, owner, "after joining")
+	readOutput(t, watcher, "after joining")
+
+	// Revoking the link disconnects the spectator and kills the link
+	sendControl(t, owner, controlMessage{Type: msgUnshare})
+	if _, reason := readClose(t, watcher); reason != "share link revoked" {
+		t.Errorf("spectator closed with %q, want %q", reason, "share link revoked")
+	}
+	resp, err := ts.Client().Get(ts.URL + "/ws/watch?token=" + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("revoked link answered %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestSessionCommandAudit(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	s, ts, started := newTestServer(t, Options{AuditLog: auditPath})
+	conn, f := startSession(t, s, ts, started)
+	if f.Spec.CommandPipe == nil {
+		t.Fatal("the TUI got no command pipe")
+	}
+
+	// Output can't claim to be a command, however it's dressed up
+	f.Emit([]byte("\x1b]7777;marcli-command=rm-rf\a"))
+	readOutput(t, conn, "marcli-command=rm-rf")
+	fmt.Fprintln(f.Spec.CommandPipe, "mega-combine")
+	f.Exit(0)
+	readClose(t, conn)
+
+	var commands []string
+	deadline := time.Now().Add(testTimeout)
+	for ended := false; !ended || len(commands) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the session end was never audited")
+		}
+		data, _ := os.ReadFile(auditPath)
+		commands = nil
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var event AuditEvent
+			json.Unmarshal([]byte(line), &event)
+			switch event.Event {
+			case auditCommand:
+				commands = append(commands, event.Command)
+			case auditSessionEnd:
+				ended = true
+			}
+		}
+	}
+	if len(commands) != 1 || commands[0] != "mega-combine" {
+		t.Errorf("audited commands %q, want only the reported one", commands)
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"time"
+)
+
+// Spec describes the program a terminal session runs
+type Spec struct {
+	// Path is the executable to run
+	Path string
+	// Args are the arguments, not including the program itself
+	Args []string
+	// Dir is the working directory (empty means the server's)
+	Dir string
+	// Env holds extra KEY=VALUE pairs on top of the server's environment
+	Env []string
+	// UID and GID run the program as another user (Unix only, needs root)
+	UID *uint32
+	GID *uint32
+	// GracePeriod is how long Close lets the program clean up after asking
+	// it to exit before killing it (default 5s)
+	GracePeriod time.Duration
+	// CommandPipe, if set, is handed to the program for reporting the menu
+	// commands it runs (see ReportCommand). Only the program gets it, so
+	// nothing printed to the terminal can forge a report. The terminal
+	// closes it once started. Unix only - on Windows it's just closed.
+	CommandPipe *os.File
+}
+
+// String returns the command line for logs
+func (s Spec) String() string {
+	return fmt.Sprint(append([]string{s.Path}, s.Args...))
+}
+
+// Profile is a named program the web terminal may launch, from config.yml.
+// Set at most one of Command, Shell or Argv; with none of them the profile
+// runs the cutiepie TUI.
+type Profile struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Command runs a marcli subcommand, e.g. ["mega-combine", "--test"]
+	Command []string `yaml:"command"`
+	// Shell runs the user's login shell
+	Shell bool `yaml:"shell"`
+	// Argv runs any program, e.g. ["htop"]
+	Argv []string          `yaml:"argv"`
+	Dir  string            `yaml:"dir"`
+	Env  map[string]string `yaml:"env"`
+	UID  *uint32           `yaml:"uid"`
+	GID  *uint32           `yaml:"gid"`
+}
+
+// runsTUI reports whether the profile runs the cutiepie TUI
+func (p Profile) runsTUI() bool {
+	return len(p.Command) == 0 && !p.Shell && len(p.Argv) == 0
+}
+
+// defaultProfile is what the web terminal runs when no profiles are configured
+var defaultProfile = Profile{
+	Name:        "cutiepie",
+	Description: "The cutiepie TUI command launcher",
+}
+
+// Spec resolves the profile into the program to run
+func (p Profile) Spec() (Spec, error) {
+	modes := 0
+	if len(p.Command) > 0 {
+		modes++
+	}
+	if p.Shell {
+		modes++
+	}
+	if len(p.Argv) > 0 {
+		modes++
+	}
+	if modes > 1 {
+		return Spec{}, fmt.Errorf("profile %q sets more than one of command, shell and argv", p.Name)
+	}
+
+	spec := Spec{
+		Dir: p.Dir,
+		UID: p.UID,
+		GID: p.GID,
+	}
+
+	// Sort env keys so the environment is the same on every launch
+	keys := make([]string, 0, len(p.Env))
+	for k := range p.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		spec.Env = append(spec.Env, k+"="+p.Env[k])
+	}
+
+	switch {
+	case len(p.Argv) > 0:
+		path, err := exec.LookPath(p.Argv[0])
+		if err != nil {
+			return Spec{}, fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+		spec.Path = path
+		spec.Args = p.Argv[1:]
+	case p.Shell:
+		spec.Path, spec.Args = loginShell()
+	default:
+		// Run marcli itself - a subcommand, or the TUI with --stay-alive so it
+		// stays open after commands
+		execPath, err := os.Executable()
+		if err != nil {
+			return Spec{}, err
+		}
+		spec.Path = execPath
+		spec.Args = p.Command
+		if len(spec.Args) == 0 {
+			spec.Args = []string{"--stay-alive"}
+		}
+	}
+
+	return spec, nil
+}
+
+// profiles returns the profiles the browser may launch
+func (o Options) profiles() []Profile {
+	if len(o.Profiles) == 0 {
+		return []Profile{defaultProfile}
+	}
+	return o.Profiles
+}
+
+// profile finds an allowed profile by name; an empty name picks the first one
+func (o Options) profile(name string) (Profile, bool) {
+	profiles := o.profiles()
+	if name == "" {
+		return profiles[0], true
+	}
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
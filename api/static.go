@@ -0,0 +1,235 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"marcli/static"
+
+	"github.com/andybalholm/brotli"
+	logger "github.com/charmbracelet/log"
+)
+
+// asset is a precompressed, fingerprinted static file
+type asset struct {
+	contentType string
+	etag        string
+	version     string
+	raw         []byte
+	gzip        []byte
+	brotli      []byte
+}
+
+// assetServer serves the web UI. By default it serves the files embedded in
+// the binary, precompressed and with ETags. With a static dir override it
+// reads straight from disk on every request so frontend changes show up
+// without a rebuild.
+type assetServer struct {
+	fsys   fs.FS
+	dev    bool
+	assets map[string]*asset
+	pages  map[string]*template.Template
+}
+
+// pageNames are the HTML files rendered as templates rather than served as is
+var pageNames = []string{"index.html", "admin.html", "jobs.html"}
+
+// newAssetServer creates an asset server for the embedded files, or for dir
+// if it isn't empty
+func newAssetServer(dir string) (*assetServer, error) {
+	if dir != "" {
+		if _, err := os.Stat(filepath.Join(dir, "index.html")); err != nil {
+			return nil, fmt.Errorf("static dir %s has no index.html: %w", dir, err)
+		}
+		return &assetServer{fsys: os.DirFS(dir), dev: true}, nil
+	}
+
+	s := &assetServer{fsys: static.FS, assets: make(map[string]*asset)}
+
+	entries, err := fs.ReadDir(static.FS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list embedded assets: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		a, err := loadAsset(static.FS, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		s.assets[entry.Name()] = a
+	}
+
+	s.pages = make(map[string]*template.Template)
+	for _, name := range pageNames {
+		tmpl, err := s.parsePage(name)
+		if err != nil {
+			return nil, err
+		}
+		s.pages[name] = tmpl
+	}
+
+	return s, nil
+}
+
+// loadAsset reads a file and prepares its compressed variants
+func loadAsset(fsys fs.FS, name string) (*asset, error) {
+	raw, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read asset %s: %w", name, err)
+	}
+
+	sum := sha256.Sum256(raw)
+	version := hex.EncodeToString(sum[:8])
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(raw)
+	}
+
+	a := &asset{
+		contentType: contentType,
+		etag:        `"` + version + `"`,
+		version:     version,
+		raw:         raw,
+	}
+
+	// Only keep compressed variants that actually save something
+	var gz bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	gw.Write(raw)
+	gw.Close()
+	if gz.Len() < len(raw) {
+		a.gzip = gz.Bytes()
+	}
+
+	var br bytes.Buffer
+	bw := brotli.NewWriterLevel(&br, brotli.BestCompression)
+	bw.Write(raw)
+	bw.Close()
+	if br.Len() < len(raw) {
+		a.brotli = br.Bytes()
+	}
+
+	return a, nil
+}
+
+// parsePage parses an HTML page as a template. Asset URLs go through the
+// "asset" func so they carry a version for cache busting.
+func (s *assetServer) parsePage(name string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(template.FuncMap{
+		"asset": s.assetURL,
+	}).ParseFS(s.fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// assetURL returns the URL for a static file, fingerprinted when embedded
+func (s *assetServer) assetURL(name string) string {
+	if a, ok := s.assets[name]; ok {
+		return "/static/" + name + "?v=" + a.version
+	}
+	return "/static/" + name
+}
+
+// page returns an HTML page's template, re-reading it from disk in dev mode
+func (s *assetServer) page(name string) (*template.Template, error) {
+	if s.dev {
+		return s.parsePage(name)
+	}
+	tmpl, ok := s.pages[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown page %s", name)
+	}
+	return tmpl, nil
+}
+
+// ServeHTTP serves files under /static/
+func (s *assetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+
+	if s.dev {
+		w.Header().Set("Cache-Control", "no-store")
+		http.StripPrefix("/static/", http.FileServerFS(s.fsys)).ServeHTTP(w, r)
+		return
+	}
+
+	a, ok := s.assets[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Fingerprinted URLs never change, everything else revalidates with the ETag
+	if r.URL.Query().Get("v") == a.version {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("ETag", a.etag)
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, a.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body := a.raw
+	accept := r.Header.Get("Accept-Encoding")
+	switch {
+	case a.brotli != nil && acceptsEncoding(accept, "br"):
+		w.Header().Set("Content-Encoding", "br")
+		body = a.brotli
+	case a.gzip != nil && acceptsEncoding(accept, "gzip"):
+		w.Header().Set("Content-Encoding", "gzip")
+		body = a.gzip
+	}
+
+	w.Header().Set("Content-Type", a.contentType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(body); err != nil {
+		logger.Error("Failed to write asset", "name", name, "err", err)
+	}
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows the coding
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), coding) {
+			continue
+		}
+		// A q of 0 (or 0.0, 0.000...) means "not acceptable". So does a q we
+		// can't read - the plain file is always a safe answer.
+		for _, param := range fields[1:] {
+			name, value, ok := strings.Cut(param, "=")
+			if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q <= 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
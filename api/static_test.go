@@ -0,0 +1,30 @@
+package api
+
+import "testing"
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"gzip", true},
+		{"GZIP", true},
+		{"br, gzip;q=0.8", true},
+		{"deflate, gzip ; q=1.0", true},
+		{"gzip;q=0.001", true},
+		{"", false},
+		{"br", false},
+		{"gzip;q=0", false},
+		{"gzip; q = 0", false},
+		{"gzip;q=0.0", false},
+		{"gzip;Q=0.000", false},
+		{"br, gzip;q=0.000", false},
+		{"gzip;q=lots", false},
+		{"gzipped", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, "gzip"); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, gzip) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"io"
+	"time"
+)
+
+// defaultGracePeriod is how long a program gets to exit after being asked
+// to, when the Spec doesn't say
+const defaultGracePeriod = 5 * time.Second
+
+// Terminal is a program running behind a pseudo-terminal. The server only
+// talks to sessions through this interface, so tests can swap in a
+// fake terminal instead of spawning real processes.
+type Terminal interface {
+	io.ReadWriteCloser
+	// Resize changes the terminal size
+	Resize(cols, rows uint16) error
+	// Wait blocks until the program exits
+	Wait() error
+	// ExitCode returns the program's exit code, or -1 if it hasn't exited
+	// (or was killed by a signal)
+	ExitCode() int
+	// ExitSignal returns the signal that killed the program, like "SIGKILL",
+	// or "" if it exited normally or is still running
+	ExitSignal() string
+}
+
+// ExitStatus is how a terminal's program ended, as sent to the browser
+type ExitStatus struct {
+	Code   int    `json:"code"`
+	Signal string `json:"signal,omitempty"`
+}
+
+// exitStatusOf reads the exit status of a terminal whose program has exited
+func exitStatusOf(t Terminal) ExitStatus {
+	return ExitStatus{Code: t.ExitCode(), Signal: t.ExitSignal()}
+}
+
+// TerminalStarter starts a terminal running the program described by spec
+type TerminalStarter func(spec Spec) (Terminal, error)
+
+// StartPTY starts a real pseudo-terminal for spec
+func StartPTY(spec Spec) (Terminal, error) {
+	p := NewPTYManager()
+	if err := p.Start(spec); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// exitState records how a child process exited. Both PTY backends fill it in
+// from a goroutine that waits on the process.
+type exitState struct {
+	done   chan struct{}
+	err    error
+	code   int
+	signal string
+}
+
+func newExitState() *exitState {
+	return &exitState{done: make(chan struct{}), code: -1}
+}
+
+// set records the result and wakes up waiters; call it exactly once
+func (e *exitState) set(code int, signal string, err error) {
+	e.code = code
+	e.signal = signal
+	e.err = err
+	close(e.done)
+}
+
+// wait blocks until the process has exited
+func (e *exitState) wait() error {
+	<-e.done
+	return e.err
+}
+
+// exitCode returns the exit code, or -1 while the process is running
+func (e *exitState) exitCode() int {
+	select {
+	case <-e.done:
+		return e.code
+	default:
+		return -1
+	}
+}
+
+// exitSignal returns the signal that killed the process, if any
+func (e *exitState) exitSignal() string {
+	select {
+	case <-e.done:
+		return e.signal
+	default:
+		return ""
+	}
+}
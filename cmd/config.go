@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 
+	"marcli/api"
+
 	"gopkg.in/yaml.v3"
 )
 
@@ -12,6 +14,10 @@ type Config struct {
 	Version   string `yaml:"version"`   // Our cute version number! ✨
 	Build     int    `yaml:"build"`     // Build counter - we're so organized! 🎀
 	StayAlive bool   `yaml:"stayAlive"` // Whether to stay in TUI after running a command (false = exit, true = stay)
+
+	Web api.Options `yaml:"web,omitempty"` // Web terminal settings for cutiepie-tty - so secure! 🔒
+
+	MegaCombine MegaCombineConfig `yaml:"megaCombine,omitempty"` // Encoding presets for mega-combine - so flexible! 🎨
 }
 
 const configFile = "config.yml" // Where we keep our config, obviously! 💖
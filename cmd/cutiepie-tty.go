@@ -4,24 +4,70 @@ import (
 	"context"
 	"fmt"
 	"marcli/api"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 // RunCutiepieTTY starts the web-based terminal server
 func RunCutiepieTTY(ctx context.Context) (string, error) {
+	// Web settings (like allowed origins) come from config.yml if we have one
+	var opts api.Options
+	if config, err := LoadConfig(); err == nil {
+		opts = config.Web
+	}
+	if opts.Port == 0 {
+		opts.Port = 8080
+	}
+
 	// Get port from context if available
-	port := 8080
 	if ctx.Value("port") != nil {
 		if p, ok := ctx.Value("port").(int); ok {
-			port = p
+			opts.Port = p
+		}
+	}
+
+	// The registry tells us which commands the HTTP API may run 🤖
+	if commands, ok := ctx.Value("apiCommands").([]api.Command); ok {
+		opts.Commands = commands
+	}
+
+	// Serve the web UI from disk instead of the embedded copy (for frontend dev)
+	if dir, ok := ctx.Value("staticDir").(string); ok && dir != "" {
+		opts.StaticDir = dir
+	}
+
+	// Record every session as an asciicast - so cinematic! 🎬
+	if ctx.Value("record") == true {
+		opts.Record = true
+	}
+	if opts.Record {
+		dir := opts.RecordingsDir
+		if dir == "" {
+			dir = api.DefaultRecordingsDir()
 		}
+		fmt.Printf("Recording sessions to %s 🎬\n", dir)
 	}
 
-	// Start the server (this will block)
-	err := api.StartServer(port)
+	server, err := api.NewServer(opts)
 	if err != nil {
 		return "", fmt.Errorf("server error: %w", err)
 	}
 
-	return "", nil
+	// Ctrl+C or SIGTERM shuts down gracefully - browsers get told and sessions drain 💅
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.Start(ctx); err != nil {
+		return "", fmt.Errorf("server error: %w", err)
+	}
+
+	// Wait until the server has stopped
+	<-server.Done()
+	if err := server.Err(); err != nil {
+		return "", fmt.Errorf("server error: %w", err)
+	}
+
+	return "Server stopped. Bye! 💖\n", nil
 }
 
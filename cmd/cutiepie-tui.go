@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"time"
 
+	"marcli/api"
 	"marcli/ui"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -252,6 +253,9 @@ func RunCutiepieTUI(stayAliveOverride *bool) error {
 			}
 			cmd := tuiModel.GetSelectedCommand()
 			if cmd != nil {
+				// Inside cutiepie-tty, tell the server which command we're running for its audit log 🕵️
+				api.ReportCommand(cmd.name)
+
 				ctx := context.Background()
 				out, err := cmd.run(ctx)
 				if err != nil {
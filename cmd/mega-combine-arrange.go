@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"marcli/media"
+	"marcli/ui"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Arrange step styles, matching the picker 💅
+var (
+	arrangeTitleStyle  = lipgloss.NewStyle().MarginLeft(2)
+	arrangeItemStyle   = lipgloss.NewStyle().PaddingLeft(4)
+	arrangeCursorStyle = lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("170"))
+	arrangeStatusStyle = lipgloss.NewStyle().PaddingLeft(4).Foreground(lipgloss.Color("170"))
+	arrangeHelpStyle   = lipgloss.NewStyle().PaddingLeft(4).Foreground(lipgloss.Color("241"))
+	arrangeBorderStyle = lipgloss.NewStyle().
+				Border(lipgloss.RoundedBorder()).
+				BorderForeground(lipgloss.Color("129")).
+				Padding(1, 2)
+)
+
+// clipSorts are the arrange step's sort keys: how each one orders two clips
+var clipSorts = map[string]struct {
+	name string
+	less func(a, b *videoFileItem) bool
+}{
+	"n": {"name", func(a, b *videoFileItem) bool {
+		return strings.ToLower(a.title) < strings.ToLower(b.title)
+	}},
+	"m": {"modified time", func(a, b *videoFileItem) bool {
+		return a.modTime.Before(b.modTime)
+	}},
+	"c": {"creation time", func(a, b *videoFileItem) bool {
+		return a.creationTime().Before(b.creationTime())
+	}},
+	"d": {"duration", func(a, b *videoFileItem) bool {
+		return a.duration() < b.duration()
+	}},
+}
+
+// creationTime is when the clip was recorded according to its metadata,
+// falling back to when the file was modified
+func (i videoFileItem) creationTime() time.Time {
+	if i.info != nil && !i.info.CreationTime.IsZero() {
+		return i.info.CreationTime
+	}
+	return i.modTime
+}
+
+// duration is the clip's length in seconds (0 until ffprobe answers)
+func (i videoFileItem) duration() float64 {
+	if i.info == nil {
+		return 0
+	}
+	return i.info.Duration
+}
+
+// arrangeModel is the picker's second step: put the selected clips in the
+// order they'll be combined - what you see is exactly what ffmpeg gets! 🎀
+type arrangeModel struct {
+	items     []*videoFileItem
+	cursor    int
+	offset    int    // First row shown when the clips don't all fit
+	height    int    // Rows of clips that fit
+	arranged  string // How the clips were last put in order, for the status line
+	confirmed bool
+	back      bool // Back to the picker to change the selection
+	cancelled bool
+}
+
+// newArrangeModel starts arranging the picked clips, sized for a terminal
+// height (0 if it isn't known yet)
+func newArrangeModel(items []*videoFileItem, termHeight int) *arrangeModel {
+	return &arrangeModel{
+		items:    items,
+		height:   arrangeRows(termHeight),
+		arranged: "in picker order",
+	}
+}
+
+// arrangeRows is how many clips fit on screen, leaving room for the border,
+// title, help and status
+func arrangeRows(termHeight int) int {
+	if termHeight == 0 {
+		return ui.DefaultListHeight
+	}
+	return max(3, termHeight-12)
+}
+
+func (m *arrangeModel) Update(msg tea.Msg) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		if size, ok := msg.(tea.WindowSizeMsg); ok {
+			m.height = arrangeRows(size.Height)
+		}
+		return
+	}
+
+	switch key := keyMsg.String(); key {
+	case "ctrl+c":
+		m.cancelled = true
+	case "esc":
+		m.back = true
+	case "enter":
+		m.confirmed = true
+	case "up", "k":
+		m.cursor = max(0, m.cursor-1)
+	case "down", "j":
+		m.cursor = min(len(m.items)-1, m.cursor+1)
+	case "shift+up", "K":
+		m.move(-1)
+	case "shift+down", "J":
+		m.move(1)
+	case "r":
+		slices.Reverse(m.items)
+		m.cursor = len(m.items) - 1 - m.cursor
+		m.arranged = "reversed"
+	default:
+		if s, ok := clipSorts[key]; ok {
+			current := m.items[m.cursor]
+			sort.SliceStable(m.items, func(i, j int) bool {
+				return s.less(m.items[i], m.items[j])
+			})
+			m.cursor = slices.Index(m.items, current) // Keep the cursor on the same clip
+			m.arranged = "sorted by " + s.name
+		}
+	}
+
+	// Keep the cursor on screen
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	} else if m.cursor >= m.offset+m.height {
+		m.offset = m.cursor - m.height + 1
+	}
+}
+
+// move moves the clip under the cursor up (-1) or down (1), taking the cursor along
+func (m *arrangeModel) move(delta int) {
+	to := m.cursor + delta
+	if to < 0 || to >= len(m.items) {
+		return
+	}
+	m.items[m.cursor], m.items[to] = m.items[to], m.items[m.cursor]
+	m.cursor = to
+	m.arranged = "arranged by hand"
+}
+
+func (m *arrangeModel) View() string {
+	var b strings.Builder
+	b.WriteString(arrangeTitleStyle.Render("Arrange Clips (Enter: combine, Esc: back, Ctrl+C: quit)"))
+	b.WriteString("\n\n")
+
+	width := len(fmt.Sprint(len(m.items)))
+	end := min(len(m.items), m.offset+m.height)
+	for i := m.offset; i < end; i++ {
+		row := fmt.Sprintf("%*d. %s", width, i+1, m.items[i].DisplayText())
+		if i == m.cursor {
+			// Heart (💖) is the cursor, like in the picker
+			b.WriteString(arrangeCursorStyle.Render("💖 " + row))
+		} else {
+			b.WriteString(arrangeItemStyle.Render(row))
+		}
+		b.WriteString("\n")
+	}
+	if len(m.items) > m.height {
+		b.WriteString(arrangeHelpStyle.Render(fmt.Sprintf("%d-%d of %d", m.offset+1, end, len(m.items))) + "\n")
+	}
+
+	var total float64
+	for _, item := range m.items {
+		total += item.duration()
+	}
+	b.WriteString("\n" + arrangeHelpStyle.Render("shift+↑/↓ or K/J move • n name • m modified • c created • d duration • r reverse"))
+	b.WriteString("\n\n" + arrangeStatusStyle.Render(fmt.Sprintf("%d clips · %s · %s", len(m.items), media.FormatDuration(total), m.arranged)))
+	return "\n" + arrangeBorderStyle.Render(b.String())
+}
+
+// files lists the clips in their final order
+func (m *arrangeModel) files() []string {
+	files := make([]string, len(m.items))
+	for i, item := range m.items {
+		files[i] = item.filePath
+	}
+	return files
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"marcli/media"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// press sends the arrange step key presses by name
+func press(m *arrangeModel, keys ...string) {
+	for _, key := range keys {
+		var msg tea.KeyMsg
+		switch key {
+		case "up":
+			msg = tea.KeyMsg{Type: tea.KeyUp}
+		case "down":
+			msg = tea.KeyMsg{Type: tea.KeyDown}
+		case "shift+up":
+			msg = tea.KeyMsg{Type: tea.KeyShiftUp}
+		case "shift+down":
+			msg = tea.KeyMsg{Type: tea.KeyShiftDown}
+		case "enter":
+			msg = tea.KeyMsg{Type: tea.KeyEnter}
+		case "esc":
+			msg = tea.KeyMsg{Type: tea.KeyEsc}
+		default:
+			msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+		}
+		m.Update(msg)
+	}
+}
+
+// arrangeClips are four clips whose name, modified time, creation time and
+// duration orders all differ
+func arrangeClips() []*videoFileItem {
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clip := func(name string, modified, created int, seconds float64) *videoFileItem {
+		info := &media.Info{Duration: seconds}
+		if created >= 0 {
+			info.CreationTime = day.Add(time.Duration(created) * time.Hour)
+		}
+		return &videoFileItem{title: name, filePath: name, modTime: day.Add(time.Duration(modified) * time.Hour), info: info}
+	}
+	return []*videoFileItem{
+		clip("b.mp4", 3, 1, 30),
+		clip("D.mp4", 1, 4, 10),
+		clip("a.mp4", 2, 3, 20),
+		clip("c.mp4", 5, -1, 40), // No creation time, so its modified time counts
+	}
+}
+
+func TestArrangeMove(t *testing.T) {
+	m := newArrangeModel(arrangeClips(), 0)
+
+	press(m, "shift+down", "J")
+	if got := m.files(); !slices.Equal(got, []string{"D.mp4", "a.mp4", "b.mp4", "c.mp4"}) || m.cursor != 2 {
+		t.Errorf("after moving down twice: %v, cursor %d", got, m.cursor)
+	}
+	if m.arranged != "arranged by hand" {
+		t.Errorf("status = %q", m.arranged)
+	}
+
+	// Can't move past either end
+	press(m, "J", "J")
+	if got := m.files(); !slices.Equal(got, []string{"D.mp4", "a.mp4", "c.mp4", "b.mp4"}) || m.cursor != 3 {
+		t.Errorf("after moving to the end: %v, cursor %d", got, m.cursor)
+	}
+	press(m, "up", "up", "up", "up", "shift+up")
+	if got := m.files(); !slices.Equal(got, []string{"D.mp4", "a.mp4", "c.mp4", "b.mp4"}) || m.cursor != 0 {
+		t.Errorf("after moving past the top: %v, cursor %d", got, m.cursor)
+	}
+	press(m, "down", "K")
+	if got := m.files(); !slices.Equal(got, []string{"a.mp4", "D.mp4", "c.mp4", "b.mp4"}) || m.cursor != 0 {
+		t.Errorf("after moving up: %v, cursor %d", got, m.cursor)
+	}
+}
+
+func TestArrangeSort(t *testing.T) {
+	tests := []struct {
+		key      string
+		want     []string
+		arranged string
+	}{
+		{"n", []string{"a.mp4", "b.mp4", "c.mp4", "D.mp4"}, "sorted by name"},
+		{"m", []string{"D.mp4", "a.mp4", "b.mp4", "c.mp4"}, "sorted by modified time"},
+		{"c", []string{"b.mp4", "a.mp4", "D.mp4", "c.mp4"}, "sorted by creation time"},
+		{"d", []string{"D.mp4", "a.mp4", "b.mp4", "c.mp4"}, "sorted by duration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arranged, func(t *testing.T) {
+			m := newArrangeModel(arrangeClips(), 0)
+			press(m, "down", "down") // On a.mp4
+			press(m, tt.key)
+			if got := m.files(); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+			if m.items[m.cursor].title != "a.mp4" {
+				t.Errorf("cursor moved to %s, want it to stay on a.mp4", m.items[m.cursor].title)
+			}
+			if m.arranged != tt.arranged {
+				t.Errorf("status = %q, want %q", m.arranged, tt.arranged)
+			}
+		})
+	}
+}
+
+func TestArrangeReverse(t *testing.T) {
+	m := newArrangeModel(arrangeClips(), 0)
+	press(m, "down", "r")
+	if got := m.files(); !slices.Equal(got, []string{"c.mp4", "a.mp4", "D.mp4", "b.mp4"}) {
+		t.Errorf("files = %v", got)
+	}
+	if m.items[m.cursor].title != "D.mp4" || m.arranged != "reversed" {
+		t.Errorf("cursor on %s, status %q, want D.mp4 and reversed", m.items[m.cursor].title, m.arranged)
+	}
+}
+
+func TestArrangeScroll(t *testing.T) {
+	// A 15 row terminal fits 3 clips
+	m := newArrangeModel(arrangeClips(), 15)
+	press(m, "down", "down", "down")
+	if m.offset != 1 {
+		t.Errorf("offset = %d, want 1 to keep the cursor on screen", m.offset)
+	}
+	press(m, "up", "up", "up")
+	if m.offset != 0 {
+		t.Errorf("offset = %d, want 0", m.offset)
+	}
+}
+
+func TestArrangeExit(t *testing.T) {
+	for key, check := range map[string]func(*arrangeModel) bool{
+		"enter": func(m *arrangeModel) bool { return m.confirmed },
+		"esc":   func(m *arrangeModel) bool { return m.back },
+	} {
+		m := newArrangeModel(arrangeClips(), 0)
+		press(m, key)
+		if !check(m) {
+			t.Errorf("%s didn't leave the arrange step", key)
+		}
+	}
+	m := newArrangeModel(arrangeClips(), 0)
+	m.Update(tea.KeyMsg{Type: tea.KeyCtrlC})
+	if !m.cancelled {
+		t.Error("ctrl+c didn't cancel")
+	}
+}
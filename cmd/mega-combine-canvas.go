@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"marcli/media"
+)
+
+// canvasSizes are shorthands for --size, all landscape
+var canvasSizes = map[string]string{
+	"720p":  "1280x720",
+	"1080p": "1920x1080",
+	"1440p": "2560x1440",
+	"4k":    "3840x2160",
+	"2160p": "3840x2160",
+}
+
+// canvas is the frame every clip gets fitted into when re-encoding - so tidy! 🖼️
+type canvas struct {
+	width  int
+	height int
+	fps    float64
+	fit    string // "pad" (letterbox/pillarbox) or "crop" (fill the frame)
+}
+
+// resolveCanvas works out the target frame from --size, --fps and --fit. Anything
+// not given comes from the majority of the probed clips (display size, so
+// rotated phone clips count as portrait). A zero size or fps means nobody
+// knows, and the clips are left as they are.
+func resolveCanvas(ctx context.Context, infos []*media.Info) (canvas, error) {
+	c := canvas{fit: "pad"}
+	if fit, ok := ctx.Value("megaCombineFit").(string); ok {
+		if fit != "pad" && fit != "crop" {
+			return c, fmt.Errorf("invalid --fit %q (want pad or crop)", fit)
+		}
+		c.fit = fit
+	}
+
+	if size, ok := ctx.Value("megaCombineSize").(string); ok {
+		w, h, err := parseCanvasSize(size)
+		if err != nil {
+			return c, err
+		}
+		c.width, c.height = w, h
+	} else {
+		c.width, c.height = majoritySize(infos)
+	}
+
+	if fps, ok := ctx.Value("megaCombineFPS").(string); ok {
+		rate, err := parseCanvasFPS(fps)
+		if err != nil {
+			return c, err
+		}
+		c.fps = rate
+	} else {
+		c.fps = majorityFPS(infos)
+	}
+	return c, nil
+}
+
+// parseCanvasSize understands "1920x1080" and shorthands like "1080p" or "4k"
+func parseCanvasSize(size string) (int, int, error) {
+	if named, ok := canvasSizes[strings.ToLower(size)]; ok {
+		size = named
+	}
+	ws, hs, ok := strings.Cut(strings.ToLower(size), "x")
+	w, err1 := strconv.Atoi(ws)
+	h, err2 := strconv.Atoi(hs)
+	if !ok || err1 != nil || err2 != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid --size %q (want WIDTHxHEIGHT like 1920x1080, or 720p/1080p/1440p/4k)", size)
+	}
+	if w%2 != 0 || h%2 != 0 {
+		return 0, 0, fmt.Errorf("invalid --size %q: width and height must be even for the encoders", size)
+	}
+	return w, h, nil
+}
+
+// parseCanvasFPS understands "30", "29.97" and "30000/1001"
+func parseCanvasFPS(fps string) (float64, error) {
+	num, den, isRatio := strings.Cut(fps, "/")
+	rate, err := strconv.ParseFloat(num, 64)
+	if err == nil && isRatio {
+		var d float64
+		d, err = strconv.ParseFloat(den, 64)
+		if d == 0 {
+			err = fmt.Errorf("zero denominator")
+		}
+		rate /= d
+	}
+	if err != nil || rate <= 0 {
+		return 0, fmt.Errorf("invalid --fps %q (want a rate like 30, 29.97 or 30000/1001)", fps)
+	}
+	return rate, nil
+}
+
+// majoritySize finds the most common display size, earliest clip first on a tie
+func majoritySize(infos []*media.Info) (int, int) {
+	var sizes [][2]int
+	for _, info := range infos {
+		if info != nil && info.Video != nil && info.Video.Width > 0 {
+			w, h := info.Video.DisplaySize()
+			sizes = append(sizes, [2]int{w, h})
+		}
+	}
+	best := majority(sizes)
+	return best[0], best[1]
+}
+
+// majorityFPS finds the most common frame rate (to the hundredth), earliest clip first on a tie
+func majorityFPS(infos []*media.Info) float64 {
+	var rates []string
+	byLabel := make(map[string]float64)
+	for _, info := range infos {
+		if info != nil && info.Video != nil && info.Video.FrameRate > 0 {
+			label := media.FormatFrameRate(info.Video.FrameRate)
+			rates = append(rates, label)
+			if _, ok := byLabel[label]; !ok {
+				byLabel[label] = info.Video.FrameRate
+			}
+		}
+	}
+	return byLabel[majority(rates)]
+}
+
+// majority returns the most common value, earliest first on a tie
+func majority[T comparable](values []T) T {
+	counts := make(map[T]int)
+	for _, v := range values {
+		counts[v]++
+	}
+	var best T
+	top := 0
+	for _, v := range values {
+		if counts[v] > top {
+			best, top = v, counts[v]
+		}
+	}
+	return best
+}
+
+// filter returns the video filters that fit a clip onto the canvas, with
+// square pixels and the target frame rate. ffmpeg applies rotation metadata
+// before filtering, so phone clips arrive the right way up.
+func (c canvas) filter() string {
+	var filters []string
+	if c.width > 0 && c.height > 0 {
+		if c.fit == "crop" {
+			filters = append(filters,
+				fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase", c.width, c.height),
+				fmt.Sprintf("crop=%d:%d", c.width, c.height))
+		} else {
+			filters = append(filters,
+				fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", c.width, c.height),
+				fmt.Sprintf("pad=%d:%d:(ow-iw)/2:(oh-ih)/2", c.width, c.height))
+		}
+		filters = append(filters, "setsar=1")
+	}
+	if c.fps > 0 {
+		filters = append(filters, "fps="+media.FrameRateExpr(c.fps))
+	}
+	return strings.Join(filters, ",")
+}
+
+// String describes the canvas for the log, like "1920x1080 @ 29.97fps (pad)"
+func (c canvas) String() string {
+	size := "original size"
+	if c.width > 0 {
+		size = fmt.Sprintf("%dx%d", c.width, c.height)
+	}
+	fps := "original fps"
+	if c.fps > 0 {
+		fps = media.FormatFrameRate(c.fps) + "fps"
+	}
+	return fmt.Sprintf("%s @ %s (%s)", size, fps, c.fit)
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"marcli/media"
+)
+
+// video is a probed clip of the given coded size, rotation and frame rate
+func video(width, height, rotation int, fps float64) *media.Info {
+	return &media.Info{Video: &media.VideoStream{Width: width, Height: height, Rotation: rotation, FrameRate: fps}}
+}
+
+func TestMajority(t *testing.T) {
+	if got := majority([]string{"A", "B", "B", "A"}); got != "A" {
+		t.Errorf("tie = %s, want the earliest, A", got)
+	}
+	if got := majority([]string{"A", "B", "B"}); got != "B" {
+		t.Errorf("majority = %s, want B", got)
+	}
+	if got := majority([]int{}); got != 0 {
+		t.Errorf("empty = %d, want the zero value", got)
+	}
+}
+
+func TestParseCanvasSize(t *testing.T) {
+	tests := []struct {
+		size string
+		w, h int
+		err  string
+	}{
+		{size: "1920x1080", w: 1920, h: 1080},
+		{size: "1080X1920", w: 1080, h: 1920},
+		{size: "1080p", w: 1920, h: 1080},
+		{size: "4K", w: 3840, h: 2160},
+		{size: "720p", w: 1280, h: 720},
+		{size: "1921x1080", err: "must be even"},
+		{size: "1920", err: "invalid --size"},
+		{size: "0x1080", err: "invalid --size"},
+		{size: "-1920x1080", err: "invalid --size"},
+		{size: "widexhigh", err: "invalid --size"},
+	}
+	for _, tt := range tests {
+		w, h, err := parseCanvasSize(tt.size)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseCanvasSize(%q) err = %v, want %q", tt.size, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || w != tt.w || h != tt.h {
+			t.Errorf("parseCanvasSize(%q) = %dx%d, %v, want %dx%d", tt.size, w, h, err, tt.w, tt.h)
+		}
+	}
+}
+
+func TestParseCanvasFPS(t *testing.T) {
+	tests := []struct {
+		fps  string
+		want float64
+	}{
+		{"30", 30},
+		{"29.97", 29.97},
+		{"30000/1001", 30000.0 / 1001},
+		{"25/1", 25},
+	}
+	for _, tt := range tests {
+		if got, err := parseCanvasFPS(tt.fps); err != nil || got != tt.want {
+			t.Errorf("parseCanvasFPS(%q) = %v, %v, want %v", tt.fps, got, err, tt.want)
+		}
+	}
+	for _, fps := range []string{"", "0", "-30", "30/0", "fast", "30/x"} {
+		if got, err := parseCanvasFPS(fps); err == nil {
+			t.Errorf("parseCanvasFPS(%q) = %v, want an error", fps, got)
+		}
+	}
+}
+
+func TestResolveCanvas(t *testing.T) {
+	landscape := video(1920, 1080, 0, 30)
+	phone := video(1920, 1080, 90, 29.97)
+
+	tests := []struct {
+		name  string
+		flags map[string]string
+		infos []*media.Info
+		want  canvas
+		err   string
+	}{
+		{
+			name:  "majority",
+			infos: []*media.Info{landscape, video(3840, 2160, 0, 60), landscape},
+			want:  canvas{width: 1920, height: 1080, fps: 30, fit: "pad"},
+		},
+		{
+			name:  "rotated clips count as portrait",
+			infos: []*media.Info{phone, phone, landscape},
+			want:  canvas{width: 1080, height: 1920, fps: 29.97, fit: "pad"},
+		},
+		{
+			name:  "tie goes to the earliest clip",
+			infos: []*media.Info{landscape, phone, phone, landscape},
+			want:  canvas{width: 1920, height: 1080, fps: 30, fit: "pad"},
+		},
+		{
+			name:  "nothing probed",
+			infos: []*media.Info{nil, {}},
+			want:  canvas{fit: "pad"},
+		},
+		{
+			name:  "flags win",
+			flags: map[string]string{"megaCombineSize": "4k", "megaCombineFPS": "30000/1001", "megaCombineFit": "crop"},
+			infos: []*media.Info{landscape},
+			want:  canvas{width: 3840, height: 2160, fps: 30000.0 / 1001, fit: "crop"},
+		},
+		{
+			name:  "bad fit",
+			flags: map[string]string{"megaCombineFit": "stretch"},
+			err:   "invalid --fit",
+		},
+		{
+			name:  "bad size",
+			flags: map[string]string{"megaCombineSize": "big"},
+			err:   "invalid --size",
+		},
+		{
+			name:  "bad fps",
+			flags: map[string]string{"megaCombineFPS": "0"},
+			err:   "invalid --fps",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			for key, value := range tt.flags {
+				ctx = context.WithValue(ctx, key, value)
+			}
+			got, err := resolveCanvas(ctx, tt.infos)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("canvas = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanvasFilter(t *testing.T) {
+	tests := []struct {
+		canvas canvas
+		want   string
+	}{
+		{canvas{}, ""},
+		{canvas{width: 1280, height: 720, fit: "pad"}, "scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1"},
+		{canvas{width: 1080, height: 1920, fps: 29.97, fit: "crop"}, "scale=1080:1920:force_original_aspect_ratio=increase,crop=1080:1920,setsar=1,fps=30000/1001"},
+		{canvas{fps: 25}, "fps=25"},
+	}
+	for _, tt := range tests {
+		if got := tt.canvas.filter(); got != tt.want {
+			t.Errorf("%+v filter = %q, want %q", tt.canvas, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"marcli/media"
+
+	logger "github.com/charmbracelet/log"
+)
+
+// chooseEncoder picks the video encoder for a re-encoding preset: the one
+// --encoder names, or else the first in the preset's chain this machine can
+// actually use. It returns the preset with that encoder and a note saying
+// which one it is and why - so no more NVENC-only surprises! 💪
+func chooseEncoder(ctx context.Context, preset Preset) (Preset, string, error) {
+	override, _ := ctx.Value("megaCombineEncoder").(string)
+	if override == "" && len(preset.Fallbacks) == 0 {
+		return preset, "", nil // Nothing to choose from
+	}
+
+	caps, err := media.DetectCapabilities(ctx)
+	if err != nil {
+		logger.Warn("Couldn't ask ffmpeg which encoders it has", "err", err)
+	}
+
+	if override != "" {
+		if caps != nil && !caps.Encoders[override] {
+			return preset, "", fmt.Errorf("this ffmpeg has no %s encoder (see `ffmpeg -encoders`)", override)
+		}
+		// A codec from the chain keeps its settings; anything else gets ffmpeg's defaults
+		encoder := VideoEncoder{VideoCodec: override}
+		for _, e := range preset.encoders() {
+			if e.VideoCodec == override {
+				encoder = e
+			}
+		}
+		preset.VideoEncoder = encoder
+		return preset, fmt.Sprintf("Encoder: %s (picked with --encoder)", override), nil
+	}
+
+	if caps == nil {
+		return preset, fmt.Sprintf("Encoder: %s (couldn't check what this ffmpeg supports)", preset.VideoCodec), nil
+	}
+	var skipped []string
+	for _, encoder := range preset.encoders() {
+		if caps.CanEncode(ctx, encoder.VideoCodec) {
+			note := fmt.Sprintf("Encoder: %s", encoder.VideoCodec)
+			if len(skipped) > 0 {
+				note += fmt.Sprintf(" (%s not available on this machine)", strings.Join(skipped, ", "))
+			}
+			preset.VideoEncoder = encoder
+			return preset, note, nil
+		}
+		skipped = append(skipped, encoder.VideoCodec)
+	}
+	return preset, "", fmt.Errorf("none of the %s preset's encoders work here (%s) - try --encoder", preset.Name, strings.Join(skipped, ", "))
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"marcli/media"
+)
+
+// fakeFFmpeg points media.FFmpeg at a script that lists encoders and hwaccels
+// and answers trial encodes with trialExit
+func fakeFFmpeg(t *testing.T, encoders, hwaccels []string, trialExit int) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+	var list strings.Builder
+	for _, encoder := range encoders {
+		list.WriteString(" V....D " + encoder + "  test encoder\\n")
+	}
+	script := "#!/bin/sh\ncase \"$2\" in\n" +
+		"-encoders) printf 'Encoders:\\n V..... = Video\\n ------\\n" + list.String() + "' ;;\n" +
+		"-hwaccels) printf 'Hardware acceleration methods:\\n" + strings.Join(hwaccels, "\\n") + "\\n' ;;\n" +
+		"*) exit " + strconv.Itoa(trialExit) + " ;;\nesac\n"
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := media.FFmpeg
+	media.FFmpeg = path
+	t.Cleanup(func() { media.FFmpeg = old })
+}
+
+func TestChooseEncoder(t *testing.T) {
+	slowbutsmall, err := findPreset(builtinPresets, "slowbutsmall")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		encoders  []string
+		hwaccels  []string
+		trialExit int
+		override  string
+		codec     string
+		pixFmt    string
+		note      string
+		err       string
+	}{
+		{
+			name:     "gpu",
+			encoders: []string{"hevc_nvenc", "libx265", "libx264"},
+			hwaccels: []string{"cuda"},
+			codec:    "hevc_nvenc",
+			pixFmt:   "p010le",
+			note:     "Encoder: hevc_nvenc",
+		},
+		{
+			name:     "nvenc built in but no cuda",
+			encoders: []string{"hevc_nvenc", "libx265", "libx264"},
+			codec:    "libx265",
+			pixFmt:   "yuv420p10le",
+			note:     "Encoder: libx265 (hevc_nvenc not available on this machine)",
+		},
+		{
+			name:      "no gpu to encode on",
+			encoders:  []string{"hevc_nvenc", "libx264"},
+			hwaccels:  []string{"cuda"},
+			trialExit: 1,
+			codec:     "libx264",
+			pixFmt:    "yuv420p",
+			note:      "Encoder: libx264 (hevc_nvenc, libx265 not available on this machine)",
+		},
+		{
+			name:     "nothing works",
+			encoders: []string{"mpeg4"},
+			err:      "none of the slowbutsmall preset's encoders work here (hevc_nvenc, libx265, libx264)",
+		},
+		{
+			name:     "override from the chain keeps its settings",
+			encoders: []string{"hevc_nvenc", "libx265", "libx264"},
+			hwaccels: []string{"cuda"},
+			override: "libx264",
+			codec:    "libx264",
+			pixFmt:   "yuv420p",
+			note:     "Encoder: libx264 (picked with --encoder)",
+		},
+		{
+			name:     "override outside the chain",
+			encoders: []string{"libsvtav1"},
+			override: "libsvtav1",
+			codec:    "libsvtav1",
+			note:     "Encoder: libsvtav1 (picked with --encoder)",
+		},
+		{
+			name:     "override ffmpeg doesn't have",
+			encoders: []string{"libx264"},
+			override: "libx265",
+			err:      "this ffmpeg has no libx265 encoder",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFFmpeg(t, tt.encoders, tt.hwaccels, tt.trialExit)
+			ctx := context.Background()
+			if tt.override != "" {
+				ctx = context.WithValue(ctx, "megaCombineEncoder", tt.override)
+			}
+
+			preset, note, err := chooseEncoder(ctx, slowbutsmall)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if preset.VideoCodec != tt.codec || preset.PixFmt != tt.pixFmt || note != tt.note {
+				t.Errorf("got %s (%s) %q, want %s (%s) %q", preset.VideoCodec, preset.PixFmt, note, tt.codec, tt.pixFmt, tt.note)
+			}
+		})
+	}
+}
+
+func TestChooseEncoderWithoutFFmpeg(t *testing.T) {
+	old := media.FFmpeg
+	media.FFmpeg = filepath.Join(t.TempDir(), "no-ffmpeg-here")
+	t.Cleanup(func() { media.FFmpeg = old })
+
+	slowbutsmall, _ := findPreset(builtinPresets, "slowbutsmall")
+	preset, note, err := chooseEncoder(context.Background(), slowbutsmall)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preset.VideoCodec != "hevc_nvenc" || note != "Encoder: hevc_nvenc (couldn't check what this ffmpeg supports)" {
+		t.Errorf("got %s %q, want the preset's first encoder unchecked", preset.VideoCodec, note)
+	}
+
+	// Presets without fallbacks don't even ask
+	prores, _ := findPreset(builtinPresets, "waytoobig")
+	if _, note, err := chooseEncoder(context.Background(), prores); err != nil || note != "" {
+		t.Errorf("waytoobig = %q, %v, want no note", note, err)
+	}
+}
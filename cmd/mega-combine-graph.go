@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"marcli/media"
+
+	logger "github.com/charmbracelet/log"
+)
+
+// concatAudioFormat is what every clip's audio is converted to before concat,
+// so real audio and generated silence always line up
+const concatAudioFormat = "aformat=sample_rates=48000:channel_layouts=stereo"
+
+// probeClips probes the clips for the filter graph. Clips that can't be
+// probed get a nil entry and are assumed to have one audio track.
+func probeClips(ctx context.Context, files []string) []*media.Info {
+	infos := make([]*media.Info, len(files))
+	for i, r := range media.ProbeAll(ctx, files) {
+		if errors.Is(r.Err, media.ErrFFprobeNotFound) {
+			logger.Warn("ffprobe not found, assuming every clip has audio")
+			return infos
+		}
+		if r.Err != nil {
+			logger.Warn("Failed to probe video, assuming it has audio", "file", r.Path, "err", r.Err)
+		}
+		infos[i] = r.Info
+	}
+	return infos
+}
+
+// concatFilter builds the filter_complex that fits every clip onto the canvas,
+// normalizes timestamps and concatenates them. Clips without audio get silence
+// of the same length, and with mixAudio every audio track of a clip is mixed
+// together instead of just using the first one 🎀
+func concatFilter(infos []*media.Info, target canvas, mixAudio bool) string {
+	fit := target.filter()
+	if fit != "" {
+		fit += ","
+	}
+
+	var filter strings.Builder
+	for i, info := range infos {
+		// Same frame size, SAR and fps for everyone (the concat filter insists),
+		// then normalize timestamps to handle VFR/mismatched starts safely
+		filter.WriteString(fmt.Sprintf("[%d:v:0]%ssetpts=PTS-STARTPTS[v%d];", i, fit, i))
+
+		switch {
+		case info != nil && !info.HasAudio():
+			// Silent clip (screen recording, drone footage...): make some silence to match.
+			// atrim takes duration=0 as "no limit", so when the length is unknown
+			// we make a single sample and let concat pad it out to the video.
+			trim := fmt.Sprintf("atrim=duration=%.3f", info.Duration)
+			if info.Duration <= 0 {
+				trim = "atrim=end_sample=1"
+			}
+			filter.WriteString(fmt.Sprintf("anullsrc=r=48000:cl=stereo,%s,asetpts=PTS-STARTPTS[a%d];", trim, i))
+		case mixAudio && info != nil && len(info.Audio) > 1:
+			for track := range info.Audio {
+				filter.WriteString(fmt.Sprintf("[%d:a:%d]", i, track))
+			}
+			filter.WriteString(fmt.Sprintf("amix=inputs=%d:duration=longest,%s,asetpts=PTS-STARTPTS[a%d];", len(info.Audio), concatAudioFormat, i))
+		default:
+			filter.WriteString(fmt.Sprintf("[%d:a:0]%s,asetpts=PTS-STARTPTS[a%d];", i, concatAudioFormat, i))
+		}
+	}
+
+	// Concat the normalized streams
+	for i := range infos {
+		filter.WriteString(fmt.Sprintf("[v%d][a%d]", i, i))
+	}
+	filter.WriteString(fmt.Sprintf("concat=n=%d:v=1:a=1[outv][outa]", len(infos)))
+	return filter.String()
+}
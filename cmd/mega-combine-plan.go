@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"marcli/media"
+)
+
+// tempFile is a file a plan writes before ffmpeg runs and removes afterwards
+type tempFile struct {
+	path    string
+	content string
+}
+
+// ffmpegPlan is one ffmpeg run worked out ahead of time, so --test shows
+// exactly what running does - no surprises! 💕
+type ffmpegPlan struct {
+	groups    [][]string     // ffmpeg's arguments, grouped into the lines of the preview
+	dirs      []string       // Directories to create first
+	tempFiles []tempFile     // Files to write first and remove afterwards
+	output    string         // The file ffmpeg writes
+	clips     []progressClip // The inputs, for the progress bar
+}
+
+// add appends a group of arguments, shown on one line in the preview
+func (p *ffmpegPlan) add(args ...string) {
+	p.groups = append(p.groups, args)
+}
+
+// setOverwrite puts -y (replace the output) or -n (never replace it) first, so
+// ffmpeg never stops to ask - its stderr is ours and stdin isn't connected
+func (p *ffmpegPlan) setOverwrite(overwrite bool) {
+	flag := "-n"
+	if overwrite {
+		flag = "-y"
+	}
+	p.groups = append([][]string{{flag}}, p.groups...)
+}
+
+// confirmOverwrite checks whether an existing output may be replaced: it asks
+// in a terminal, and otherwise it's an error unless --force says so
+func confirmOverwrite(output string) (bool, error) {
+	if _, err := os.Stat(output); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if !isTerminal(os.Stdin) {
+		return false, fmt.Errorf("%s already exists - pass --force to overwrite it", output)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s already exists. Overwrite it? [y/N]: ", output)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, fmt.Errorf("aborted: %s already exists", output)
+}
+
+// args flattens the plan into ffmpeg's argv (without "ffmpeg" itself)
+func (p ffmpegPlan) args() []string {
+	var args []string
+	for _, group := range p.groups {
+		args = append(args, group...)
+	}
+	return args
+}
+
+// run writes the temp files, runs ffmpeg with a progress bar and cleans up.
+// That adds -nostats -progress pipe:1, which only change how ffmpeg reports
+// progress, so render leaves them out.
+func (p ffmpegPlan) run() error {
+	for _, dir := range p.dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	for _, f := range p.tempFiles {
+		if err := os.WriteFile(f.path, []byte(f.content), 0644); err != nil {
+			return fmt.Errorf("failed to create %s: %w", f.path, err)
+		}
+		defer os.Remove(f.path) // Clean up after we're done
+	}
+
+	return runFFmpeg(p.args(), p.output, p.clips)
+}
+
+// defaultShell is the shell --test renders for unless --shell says otherwise
+func defaultShell() string {
+	if runtime.GOOS == "windows" {
+		return "pwsh"
+	}
+	return "bash"
+}
+
+// render turns the plan into a script for bash or pwsh that does exactly what
+// run does, temp files and all - copy, paste, go! ✨
+func (p ffmpegPlan) render(shell string) string {
+	quote, continuation := bashQuote, " \\"
+	if shell == "pwsh" {
+		quote, continuation = pwshQuote, " `"
+	}
+
+	var b strings.Builder
+	for _, dir := range p.dirs {
+		if shell == "pwsh" {
+			fmt.Fprintf(&b, "New-Item -ItemType Directory -Force -Path %s | Out-Null\n", quote(dir))
+		} else {
+			fmt.Fprintf(&b, "mkdir -p %s\n", quote(dir))
+		}
+	}
+	for _, f := range p.tempFiles {
+		if shell == "pwsh" {
+			fmt.Fprintf(&b, "Set-Content -LiteralPath %s -Value @'\n%s\n'@\n", quote(f.path), strings.TrimSuffix(f.content, "\n"))
+		} else {
+			fmt.Fprintf(&b, "cat > %s <<'EOF'\n%sEOF\n", quote(f.path), f.content)
+		}
+	}
+
+	b.WriteString("ffmpeg")
+	for _, group := range p.groups {
+		quoted := make([]string, len(group))
+		for i, arg := range group {
+			quoted[i] = quote(arg)
+		}
+		b.WriteString(continuation + "\n  " + strings.Join(quoted, " "))
+	}
+	b.WriteString("\n")
+
+	for _, f := range p.tempFiles {
+		if shell == "pwsh" {
+			fmt.Fprintf(&b, "Remove-Item -LiteralPath %s\n", quote(f.path))
+		} else {
+			fmt.Fprintf(&b, "rm %s\n", quote(f.path))
+		}
+	}
+	return b.String()
+}
+
+// shellSafe reports whether an argument needs no quoting in either shell
+func shellSafe(arg string) bool {
+	return arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./:=,+") == ""
+}
+
+// bashQuote single-quotes an argument for bash when it needs it
+func bashQuote(arg string) string {
+	if shellSafe(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// pwshQuote single-quotes an argument for PowerShell when it needs it
+func pwshQuote(arg string) string {
+	if shellSafe(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", "''") + "'"
+}
+
+// filelistPath is where fast mode's concat list goes while ffmpeg runs
+func filelistPath(outputFile string) string {
+	base := strings.TrimSuffix(filepath.Base(outputFile), filepath.Ext(outputFile))
+	return filepath.Join(filepath.Dir(outputFile), "."+base+"-filelist.txt")
+}
+
+// buildCombinePlan works out the ffmpeg run that combines the selected files
+// with a preset: copying (concat demuxer, no re-encoding) or re-encoding with its codec settings
+// infos are the probed clips, so silent clips get silence and the progress bar knows how long each is,
+// and target is the canvas they're fitted onto when re-encoding
+func buildCombinePlan(selectedFiles []string, infos []*media.Info, target canvas, mixAudio bool, outputFile string, preset Preset) (ffmpegPlan, error) {
+	plan := ffmpegPlan{output: outputFile, clips: progressClips(selectedFiles, infos)}
+	if len(selectedFiles) == 0 {
+		return plan, fmt.Errorf("no files selected")
+	}
+
+	// Get absolute path for each file to ensure ffmpeg can find them
+	absFiles := make([]string, len(selectedFiles))
+	for i, file := range selectedFiles {
+		absFilePath, err := filepath.Abs(file)
+		if err != nil {
+			return plan, fmt.Errorf("failed to get absolute path for %s: %w", file, err)
+		}
+		absFiles[i] = absFilePath
+	}
+
+	if preset.Copy {
+		// Copying: Use concat demuxer (no re-encoding, just concatenate)
+		var filelist strings.Builder
+		for _, file := range absFiles {
+			// Escape single quotes in the path for the filelist format
+			fmt.Fprintf(&filelist, "file '%s'\n", strings.ReplaceAll(file, "'", `'\''`))
+		}
+		listPath := filelistPath(outputFile)
+		plan.tempFiles = append(plan.tempFiles, tempFile{path: listPath, content: filelist.String()})
+
+		plan.add("-f", "concat", "-safe", "0", "-i", listPath)
+		plan.add("-c", "copy") // Copy streams without re-encoding
+		plan.add(outputFile)
+		return plan, nil
+	}
+
+	// Encoding modes: Use filter_complex with timestamp normalization
+	for _, file := range absFiles {
+		plan.add("-i", file)
+	}
+	plan.add("-filter_complex", concatFilter(infos, target, mixAudio))
+	plan.add("-map", "[outv]", "-map", "[outa]")
+
+	for _, group := range preset.codecArgs() {
+		plan.add(group...)
+	}
+	plan.add(outputFile)
+	return plan, nil
+}
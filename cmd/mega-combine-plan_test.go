@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"marcli/media"
+)
+
+// absPath is what buildCombinePlan hands ffmpeg for a file
+func absPath(t *testing.T, file string) string {
+	t.Helper()
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return abs
+}
+
+// stereo is the audio of a typical clip: one AAC stereo track
+func stereo() []media.AudioStream {
+	return []media.AudioStream{{Codec: "aac", Channels: 2, ChannelLayout: "stereo", SampleRate: 48000}}
+}
+
+func TestBuildCombinePlan(t *testing.T) {
+	a, b := absPath(t, "a.mp4"), absPath(t, "it's b.mov")
+	reencode := Preset{Name: "small", VideoEncoder: VideoEncoder{VideoCodec: "libx264", Quality: "-crf 20"}, AudioCodec: "aac", Extension: ".mp4"}
+	hd := canvas{width: 1920, height: 1080, fps: 30, fit: "pad"}
+	fit := "scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30"
+	codec := []string{"-c:v", "libx264", "-crf", "20", "-c:a", "aac", "-ar", "48000", "-ac", "2"}
+
+	tests := []struct {
+		name     string
+		infos    []*media.Info
+		target   canvas
+		mixAudio bool
+		preset   Preset
+		args     []string
+		filelist string // The concat list written first, for copies
+	}{
+		{
+			name:     "copy",
+			preset:   builtinPresets[0],
+			args:     []string{"-f", "concat", "-safe", "0", "-i", ".out-filelist.txt", "-c", "copy", "out.mkv"},
+			filelist: "file '" + a + "'\nfile '" + strings.ReplaceAll(b, "'", `'\''`) + "'\n",
+		},
+		{
+			name:   "re-encode",
+			infos:  []*media.Info{{Duration: 10, Audio: stereo()}, {Duration: 5, Audio: stereo()}},
+			target: hd,
+			preset: reencode,
+			args: append([]string{"-i", a, "-i", b, "-filter_complex",
+				"[0:v:0]" + fit + ",setpts=PTS-STARTPTS[v0];[0:a:0]" + concatAudioFormat + ",asetpts=PTS-STARTPTS[a0];" +
+					"[1:v:0]" + fit + ",setpts=PTS-STARTPTS[v1];[1:a:0]" + concatAudioFormat + ",asetpts=PTS-STARTPTS[a1];" +
+					"[v0][a0][v1][a1]concat=n=2:v=1:a=1[outv][outa]",
+				"-map", "[outv]", "-map", "[outa]"}, append(codec, "out.mkv")...),
+		},
+		{
+			name:   "silent clip",
+			infos:  []*media.Info{{Duration: 12.5, Audio: []media.AudioStream{}}, {Duration: 5, Audio: stereo()}},
+			preset: reencode,
+			args: append([]string{"-i", a, "-i", b, "-filter_complex",
+				"[0:v:0]setpts=PTS-STARTPTS[v0];anullsrc=r=48000:cl=stereo,atrim=duration=12.500,asetpts=PTS-STARTPTS[a0];" +
+					"[1:v:0]setpts=PTS-STARTPTS[v1];[1:a:0]" + concatAudioFormat + ",asetpts=PTS-STARTPTS[a1];" +
+					"[v0][a0][v1][a1]concat=n=2:v=1:a=1[outv][outa]",
+				"-map", "[outv]", "-map", "[outa]"}, append(codec, "out.mkv")...),
+		},
+		{
+			name:   "silent clip of unknown length",
+			infos:  []*media.Info{{Audio: []media.AudioStream{}}, nil},
+			preset: reencode,
+			args: append([]string{"-i", a, "-i", b, "-filter_complex",
+				"[0:v:0]setpts=PTS-STARTPTS[v0];anullsrc=r=48000:cl=stereo,atrim=end_sample=1,asetpts=PTS-STARTPTS[a0];" +
+					"[1:v:0]setpts=PTS-STARTPTS[v1];[1:a:0]" + concatAudioFormat + ",asetpts=PTS-STARTPTS[a1];" +
+					"[v0][a0][v1][a1]concat=n=2:v=1:a=1[outv][outa]",
+				"-map", "[outv]", "-map", "[outa]"}, append(codec, "out.mkv")...),
+		},
+		{
+			name:     "multi-track clip mixed",
+			infos:    []*media.Info{{Duration: 10, Audio: append(stereo(), stereo()...)}, {Duration: 5, Audio: append(stereo(), stereo()...)}},
+			mixAudio: true,
+			preset:   reencode,
+			args: append([]string{"-i", a, "-i", b, "-filter_complex",
+				"[0:v:0]setpts=PTS-STARTPTS[v0];[0:a:0][0:a:1]amix=inputs=2:duration=longest," + concatAudioFormat + ",asetpts=PTS-STARTPTS[a0];" +
+					"[1:v:0]setpts=PTS-STARTPTS[v1];[1:a:0][1:a:1]amix=inputs=2:duration=longest," + concatAudioFormat + ",asetpts=PTS-STARTPTS[a1];" +
+					"[v0][a0][v1][a1]concat=n=2:v=1:a=1[outv][outa]",
+				"-map", "[outv]", "-map", "[outa]"}, append(codec, "out.mkv")...),
+		},
+		{
+			name:   "multi-track clip not mixed",
+			infos:  []*media.Info{{Duration: 10, Audio: append(stereo(), stereo()...)}, {Duration: 5, Audio: stereo()}},
+			preset: reencode,
+			args: append([]string{"-i", a, "-i", b, "-filter_complex",
+				"[0:v:0]setpts=PTS-STARTPTS[v0];[0:a:0]" + concatAudioFormat + ",asetpts=PTS-STARTPTS[a0];" +
+					"[1:v:0]setpts=PTS-STARTPTS[v1];[1:a:0]" + concatAudioFormat + ",asetpts=PTS-STARTPTS[a1];" +
+					"[v0][a0][v1][a1]concat=n=2:v=1:a=1[outv][outa]",
+				"-map", "[outv]", "-map", "[outa]"}, append(codec, "out.mkv")...),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := buildCombinePlan([]string{"a.mp4", "it's b.mov"}, tt.infos, tt.target, tt.mixAudio, "out.mkv", tt.preset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := plan.args(); !slices.Equal(got, tt.args) {
+				t.Errorf("args =\n%q\nwant\n%q", got, tt.args)
+			}
+			if tt.filelist == "" {
+				if len(plan.tempFiles) != 0 {
+					t.Errorf("temp files = %+v, want none", plan.tempFiles)
+				}
+				return
+			}
+			want := []tempFile{{path: ".out-filelist.txt", content: tt.filelist}}
+			if !slices.Equal(plan.tempFiles, want) {
+				t.Errorf("temp files = %q, want %q", plan.tempFiles, want)
+			}
+		})
+	}
+
+	if _, err := buildCombinePlan(nil, nil, canvas{}, false, "out.mkv", reencode); err == nil {
+		t.Error("an empty selection should be an error")
+	}
+}
+
+func TestPlanOverwrite(t *testing.T) {
+	for _, overwrite := range []bool{false, true} {
+		plan := ffmpegPlan{}
+		plan.add("-i", "a.mp4")
+		plan.add("out.mkv")
+		plan.setOverwrite(overwrite)
+		want := []string{"-n", "-i", "a.mp4", "out.mkv"}
+		if overwrite {
+			want[0] = "-y"
+		}
+		if got := plan.args(); !slices.Equal(got, want) {
+			t.Errorf("setOverwrite(%v) args = %q, want %q", overwrite, got, want)
+		}
+	}
+}
+
+func TestPlanRender(t *testing.T) {
+	plan := ffmpegPlan{
+		dirs:      []string{"/videos/my trip"},
+		tempFiles: []tempFile{{path: "/videos/my trip/.out-filelist.txt", content: "file '/videos/it'\\''s here.mp4'\nfile '/videos/plain.mp4'\n"}},
+		output:    "/videos/my trip/out.mkv",
+	}
+	plan.add("-y")
+	plan.add("-f", "concat", "-safe", "0", "-i", "/videos/my trip/.out-filelist.txt")
+	plan.add("-metadata", `title=Marc's "best" day $HOME`)
+	plan.add("/videos/my trip/out.mkv")
+
+	tests := []struct {
+		shell string
+		want  string
+	}{
+		{
+			shell: "bash",
+			want: `mkdir -p '/videos/my trip'
+cat > '/videos/my trip/.out-filelist.txt' <<'EOF'
+file '/videos/it'\''s here.mp4'
+file '/videos/plain.mp4'
+EOF
+ffmpeg \
+  -y \
+  -f concat -safe 0 -i '/videos/my trip/.out-filelist.txt' \
+  -metadata 'title=Marc'\''s "best" day $HOME' \
+  '/videos/my trip/out.mkv'
+rm '/videos/my trip/.out-filelist.txt'
+`,
+		},
+		{
+			shell: "pwsh",
+			want: `New-Item -ItemType Directory -Force -Path '/videos/my trip' | Out-Null
+Set-Content -LiteralPath '/videos/my trip/.out-filelist.txt' -Value @'
+file '/videos/it'\''s here.mp4'
+file '/videos/plain.mp4'
+'@
+ffmpeg ` + "`" + `
+  -y ` + "`" + `
+  -f concat -safe 0 -i '/videos/my trip/.out-filelist.txt' ` + "`" + `
+  -metadata 'title=Marc''s "best" day $HOME' ` + "`" + `
+  '/videos/my trip/out.mkv'
+Remove-Item -LiteralPath '/videos/my trip/.out-filelist.txt'
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			if got := plan.render(tt.shell); got != tt.want {
+				t.Errorf("render(%s) =\n%s\nwant\n%s", tt.shell, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		bash string
+		pwsh string
+	}{
+		{"out.mkv", "out.mkv", "out.mkv"},
+		{"-c:v", "-c:v", "-c:v"},
+		{"", "''", "''"},
+		{"my video.mp4", "'my video.mp4'", "'my video.mp4'"},
+		{"it's.mp4", `'it'\''s.mp4'`, "'it''s.mp4'"},
+		{`say "hi".mp4`, `'say "hi".mp4'`, `'say "hi".mp4'`},
+		{"$HOME/a.mp4", "'$HOME/a.mp4'", "'$HOME/a.mp4'"},
+		{"[outv]", "'[outv]'", "'[outv]'"},
+	}
+	for _, tt := range tests {
+		if got := bashQuote(tt.arg); got != tt.bash {
+			t.Errorf("bashQuote(%q) = %s, want %s", tt.arg, got, tt.bash)
+		}
+		if got := pwshQuote(tt.arg); got != tt.pwsh {
+			t.Errorf("pwshQuote(%q) = %s, want %s", tt.arg, got, tt.pwsh)
+		}
+	}
+}
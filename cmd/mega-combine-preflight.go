@@ -0,0 +1,428 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"marcli/media"
+
+	"github.com/charmbracelet/lipgloss"
+	logger "github.com/charmbracelet/log"
+	"github.com/mattn/go-isatty"
+)
+
+// mismatchStyle highlights the cells that don't match the other clips ⚠️
+var mismatchStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("204")).Bold(true)
+
+// compatColumns are what the concat demuxer needs to match for `-c copy` to work
+var compatColumns = []string{"video", "size", "fps", "timebase", "pixfmt", "audio"}
+
+// clipCompat is one clip's row in the compatibility report
+type clipCompat struct {
+	path   string
+	info   *media.Info
+	err    error
+	values []string // One per compatColumns entry
+}
+
+// compatReport compares every clip against the most common setup - so thorough! 🔍
+type compatReport struct {
+	clips     []clipCompat
+	reference int // Index of the clip the others should match
+}
+
+// checkCompatibility builds a report from probe results. The reference is the
+// clip whose whole setup is the most common, earliest first on a tie.
+func checkCompatibility(results []media.Result) compatReport {
+	report := compatReport{clips: make([]clipCompat, len(results))}
+	counts := make(map[string]int)
+	for i, r := range results {
+		clip := clipCompat{path: r.Path, info: r.Info, err: r.Err}
+		clip.values = compatValues(r.Info)
+		report.clips[i] = clip
+		if r.Info != nil {
+			counts[strings.Join(clip.values, "|")]++
+		}
+	}
+
+	best := -1
+	for i, clip := range report.clips {
+		if clip.info == nil {
+			continue
+		}
+		if best < 0 || counts[strings.Join(clip.values, "|")] > counts[strings.Join(report.clips[best].values, "|")] {
+			best = i
+		}
+	}
+	report.reference = max(best, 0)
+	return report
+}
+
+// compatValues turns probe info into the values compared for each column
+func compatValues(info *media.Info) []string {
+	if info == nil {
+		return []string{"?", "?", "?", "?", "?", "?"}
+	}
+	values := []string{"none", "-", "-", "-", "-", "none"}
+	if v := info.Video; v != nil {
+		values[0] = v.Codec
+		if v.Profile != "" {
+			values[0] += " " + v.Profile
+		}
+		values[1] = fmt.Sprintf("%dx%d", v.Width, v.Height)
+		if v.Rotation != 0 {
+			values[1] += fmt.Sprintf(" ↻%d", v.Rotation)
+		}
+		values[2] = media.FormatFrameRate(v.FrameRate)
+		values[3] = v.TimeBase
+		values[4] = v.PixelFormat
+	}
+	if info.HasAudio() {
+		a := info.Audio[0]
+		layout := a.ChannelLayout
+		if layout == "" {
+			layout = fmt.Sprintf("%dch", a.Channels)
+		}
+		values[5] = fmt.Sprintf("%s %s %dHz", a.Codec, layout, a.SampleRate)
+	}
+	return values
+}
+
+// mismatched reports whether a clip's column differs from the reference
+func (r compatReport) mismatched(clip, column int) bool {
+	return r.clips[clip].values[column] != r.clips[r.reference].values[column]
+}
+
+// incompatible lists the clips that don't match the reference (or couldn't be probed)
+func (r compatReport) incompatible() []int {
+	var indexes []int
+	for i, clip := range r.clips {
+		if clip.info == nil {
+			indexes = append(indexes, i)
+			continue
+		}
+		for column := range compatColumns {
+			if r.mismatched(i, column) {
+				indexes = append(indexes, i)
+				break
+			}
+		}
+	}
+	return indexes
+}
+
+// infos lists each clip's probe info, nil where probing failed
+func (r compatReport) infos() []*media.Info {
+	infos := make([]*media.Info, len(r.clips))
+	for i, clip := range r.clips {
+		infos[i] = clip.info
+	}
+	return infos
+}
+
+// compatible reports whether `-c copy` concatenation should work
+func (r compatReport) compatible() bool {
+	return len(r.incompatible()) == 0
+}
+
+// String renders the report as a table with the mismatches highlighted 💅
+func (r compatReport) String() string {
+	headers := append([]string{"#", "file"}, compatColumns...)
+	rows := make([][]string, len(r.clips))
+	for i, clip := range r.clips {
+		row := []string{strconv.Itoa(i + 1), filepath.Base(clip.path)}
+		if i == r.reference {
+			row[0] += "*"
+		}
+		rows[i] = append(row, clip.values...)
+	}
+
+	widths := make([]int, len(headers))
+	for column, header := range headers {
+		widths[column] = utf8.RuneCountInString(header)
+		for _, row := range rows {
+			widths[column] = max(widths[column], utf8.RuneCountInString(row[column]))
+		}
+	}
+	pad := func(s string, width int) string {
+		return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+	}
+
+	var b strings.Builder
+	b.WriteString("Clip compatibility for fast concat (-c copy):\n\n")
+	last := len(headers) - 1
+	for column, header := range headers[:last] {
+		b.WriteString(pad(header, widths[column]) + "  ")
+	}
+	b.WriteString(headers[last] + "\n")
+	for i, row := range rows {
+		for column, cell := range row {
+			if column < last {
+				cell = pad(cell, widths[column])
+			}
+			if column >= 2 && (r.clips[i].info == nil || r.mismatched(i, column-2)) {
+				cell = mismatchStyle.Render(cell)
+			}
+			b.WriteString(cell)
+			if column < last {
+				b.WriteString("  ")
+			}
+		}
+		if err := r.clips[i].err; err != nil {
+			b.WriteString("  " + mismatchStyle.Render("(couldn't probe)"))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n* the clip the others are compared against\n")
+	if incompatible := r.incompatible(); len(incompatible) > 0 {
+		b.WriteString(fmt.Sprintf("%d of %d clip(s) don't match - fast mode would make a broken file! 💔\n", len(incompatible), len(r.clips)))
+	} else {
+		b.WriteString("Everything matches - fast mode is good to go! ✨\n")
+	}
+	return b.String()
+}
+
+// chooseMismatchAction works out what to do about incompatible clips: the
+// --on-mismatch flag wins, otherwise we ask (when there's someone to ask).
+// Besides ask, normalize, copy and abort, any re-encoding preset's name
+// switches to that preset 💕
+func chooseMismatchAction(ctx context.Context) (string, error) {
+	action, _ := ctx.Value("megaCombineOnMismatch").(string)
+	if action == "" {
+		action = "ask"
+	}
+	switch action {
+	case "ask":
+	case "normalize", "copy", "abort":
+		return action, nil
+	default:
+		presets, err := loadPresets()
+		if err != nil {
+			return "", err
+		}
+		preset, err := findPreset(presets, action)
+		if err != nil || preset.Copy {
+			return "", fmt.Errorf("invalid --on-mismatch %q (want ask, normalize, copy, abort or a re-encoding preset)", action)
+		}
+		return action, nil
+	}
+
+	// Scripts and the job queue have no terminal to answer on
+	if !isatty.IsTerminal(os.Stdin.Fd()) && !isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		return "", fmt.Errorf("clips don't match for fast concat - pick what to do with --on-mismatch normalize|copy|abort|<preset>")
+	}
+
+	fmt.Fprint(os.Stderr, "What should we do? [s]lowbutsmall re-encode, [w]aytoobig re-encode, [n]ormalize just the odd clips, [c]opy anyway, [a]bort: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return "abort", nil
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "s", "slowbutsmall":
+		return "slowbutsmall", nil
+	case "w", "waytoobig":
+		return "waytoobig", nil
+	case "n", "normalize":
+		return "normalize", nil
+	case "c", "copy":
+		return "copy", nil
+	default:
+		return "abort", nil
+	}
+}
+
+// videoEncoders map probed codecs to the ffmpeg encoder that writes them
+var videoEncoders = map[string]string{
+	"h264":       "libx264",
+	"hevc":       "libx265",
+	"prores":     "prores_ks",
+	"vp9":        "libvpx-vp9",
+	"av1":        "libsvtav1",
+	"mpeg4":      "mpeg4",
+	"mjpeg":      "mjpeg",
+	"mpeg2video": "mpeg2video",
+}
+
+// audioEncoders map probed audio codecs to the ffmpeg encoder that writes them
+var audioEncoders = map[string]string{
+	"aac":       "aac",
+	"mp3":       "libmp3lame",
+	"opus":      "libopus",
+	"vorbis":    "libvorbis",
+	"ac3":       "ac3",
+	"flac":      "flac",
+	"pcm_s16le": "pcm_s16le",
+	"pcm_s24le": "pcm_s24le",
+}
+
+// encoderProfiles map ffprobe's profile names to what libx264/libx265 call them
+var encoderProfiles = map[string]string{
+	"Constrained Baseline": "baseline",
+	"Baseline":             "baseline",
+	"Main":                 "main",
+	"High":                 "high",
+	"High 10":              "high10",
+	"Main 10":              "main10",
+}
+
+// normalizeStep re-encodes one odd clip to match the reference clip
+type normalizeStep struct {
+	index int // Position of the clip in the selection
+	plan  ffmpegPlan
+}
+
+// normalizeDir is where the matching copies of odd clips live until the concat is done
+func normalizeDir(outputFile string) string {
+	base := strings.TrimSuffix(filepath.Base(outputFile), filepath.Ext(outputFile))
+	return filepath.Join(filepath.Dir(outputFile), "."+base+"-normalized")
+}
+
+// planNormalize works out the ffmpeg runs that make the odd clips match the
+// reference, so only they get re-encoded and the rest is still copied 🎀
+func planNormalize(report compatReport, outputFile string) ([]normalizeStep, error) {
+	ref := report.clips[report.reference].info
+	if ref == nil || ref.Video == nil {
+		return nil, fmt.Errorf("can't normalize: no clip could be probed to match against")
+	}
+	v := ref.Video
+	// ffmpeg turns the odd clips upright while re-encoding, so their copies
+	// wouldn't match a reference that's only displayed rotated
+	if v.Rotation != 0 {
+		return nil, fmt.Errorf("can't normalize: the clips to match are rotated %d° - re-encode everything with --slowbutsmall or --waytoobig instead", v.Rotation)
+	}
+	encoder, ok := videoEncoders[v.Codec]
+	if !ok {
+		return nil, fmt.Errorf("can't normalize: don't know how to encode %s video", v.Codec)
+	}
+	var audio *media.AudioStream
+	var audioEncoder string
+	if ref.HasAudio() {
+		audio = &ref.Audio[0]
+		if audioEncoder, ok = audioEncoders[audio.Codec]; !ok {
+			return nil, fmt.Errorf("can't normalize: don't know how to encode %s audio", audio.Codec)
+		}
+	}
+
+	dir := normalizeDir(outputFile)
+	ext := filepath.Ext(report.clips[report.reference].path)
+	var steps []normalizeStep
+	for _, i := range report.incompatible() {
+		clip := report.clips[i]
+		absPath, err := filepath.Abs(clip.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %s: %w", clip.path, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(clip.path), filepath.Ext(clip.path))
+		output := filepath.Join(dir, fmt.Sprintf("%03d-%s%s", i+1, name, ext))
+
+		plan := ffmpegPlan{dirs: []string{dir}, output: output, clips: progressClips([]string{clip.path}, []*media.Info{clip.info})}
+		plan.add("-y", "-i", absPath)
+		clipHasAudio := clip.info == nil || clip.info.HasAudio()
+		if audio != nil && !clipHasAudio {
+			// Silent clip: borrow silence so every clip has the same streams
+			plan.add("-f", "lavfi", "-i", fmt.Sprintf("anullsrc=r=%d:cl=%s", audio.SampleRate, channelLayout(audio)))
+		}
+		switch {
+		case audio == nil:
+			plan.add("-map", "0:v:0")
+		case clipHasAudio:
+			plan.add("-map", "0:v:0", "-map", "0:a:0")
+		default:
+			plan.add("-map", "0:v:0", "-map", "1:a:0", "-shortest")
+		}
+
+		// Fit inside the reference frame, pad the rest, and match fps and SAR
+		target := canvas{width: v.Width, height: v.Height, fps: v.FrameRate, fit: "pad"}
+		plan.add("-vf", target.filter())
+		video := []string{"-c:v", encoder, "-pix_fmt", v.PixelFormat}
+		if profile, ok := encoderProfiles[v.Profile]; ok && (encoder == "libx264" || encoder == "libx265") {
+			video = append(video, "-profile:v", profile)
+		}
+		if _, den, ok := strings.Cut(v.TimeBase, "/"); ok && (ext == ".mp4" || ext == ".mov" || ext == ".m4v") {
+			video = append(video, "-video_track_timescale", den)
+		}
+		plan.add(video...)
+		if audio != nil {
+			plan.add("-c:a", audioEncoder, "-ar", strconv.Itoa(audio.SampleRate), "-ac", strconv.Itoa(audio.Channels))
+		} else {
+			plan.add("-an")
+		}
+		plan.add(output)
+		steps = append(steps, normalizeStep{index: i, plan: plan})
+	}
+	return steps, nil
+}
+
+// runNormalize makes the matching copies of the odd clips
+func runNormalize(steps []normalizeStep) error {
+	for _, step := range steps {
+		fmt.Fprintf(os.Stderr, "Normalizing clip %d to match the others...\n", step.index+1)
+		if err := step.plan.run(); err != nil {
+			return fmt.Errorf("normalizing clip %d failed: %w", step.index+1, err)
+		}
+	}
+	return nil
+}
+
+// normalizedFiles swaps the normalized copies into the file list
+func normalizedFiles(steps []normalizeStep, files []string) []string {
+	files = append([]string(nil), files...)
+	for _, step := range steps {
+		files[step.index] = step.plan.output
+	}
+	return files
+}
+
+// channelLayout names an audio stream's layout for anullsrc
+func channelLayout(a *media.AudioStream) string {
+	if a.ChannelLayout != "" {
+		return a.ChannelLayout
+	}
+	if a.Channels == 1 {
+		return "mono"
+	}
+	return "stereo"
+}
+
+// preflightFast probes the clips before a fast concat and sorts out any that
+// don't match. It returns what to do ("copy" as planned, "normalize" to fix up
+// just the odd clips, or the name of a re-encoding preset to switch to) and
+// the report to show.
+func preflightFast(ctx context.Context, files []string, testMode bool) (string, compatReport, error) {
+	results := media.ProbeAll(ctx, files)
+	for _, r := range results {
+		if errors.Is(r.Err, media.ErrFFprobeNotFound) {
+			logger.Warn("ffprobe not found, skipping the compatibility check")
+			return "copy", compatReport{}, nil
+		}
+	}
+
+	report := checkCompatibility(results)
+	if report.compatible() {
+		return "copy", report, nil
+	}
+
+	// --test just shows the report unless --on-mismatch says what to do
+	action, _ := ctx.Value("megaCombineOnMismatch").(string)
+	if testMode && action == "" {
+		return "copy", report, nil
+	}
+	if !testMode {
+		fmt.Fprint(os.Stderr, report.String()+"\n")
+	}
+	action, err := chooseMismatchAction(ctx)
+	if err != nil {
+		return "", report, err
+	}
+	if action == "abort" {
+		return "", report, fmt.Errorf("aborted: clips don't match for fast concat")
+	}
+	return action, report, nil
+}
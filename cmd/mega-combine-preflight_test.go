@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"marcli/media"
+)
+
+// clip is a probed 1080p30 H.264 clip with stereo audio
+func clip() *media.Info {
+	return &media.Info{
+		Duration: 10,
+		Video:    &media.VideoStream{Codec: "h264", Profile: "High", Width: 1920, Height: 1080, FrameRate: 30, PixelFormat: "yuv420p", TimeBase: "1/15360"},
+		Audio:    stereo(),
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	silent := clip()
+	silent.Audio = []media.AudioStream{}
+	rotated := clip()
+	rotated.Video.Rotation = 90
+	uhd := clip()
+	uhd.Video.Width, uhd.Video.Height = 3840, 2160
+
+	tests := []struct {
+		name         string
+		infos        []*media.Info
+		reference    int
+		incompatible []int
+		mismatched   []string // Columns that differ for the first incompatible clip
+	}{
+		{
+			name:  "all match",
+			infos: []*media.Info{clip(), clip(), clip()},
+		},
+		{
+			name:         "odd one out",
+			infos:        []*media.Info{uhd, clip(), clip()},
+			reference:    1,
+			incompatible: []int{0},
+			mismatched:   []string{"size"},
+		},
+		{
+			name:         "rotated",
+			infos:        []*media.Info{clip(), rotated, clip()},
+			incompatible: []int{1},
+			mismatched:   []string{"size"},
+		},
+		{
+			name:         "silent",
+			infos:        []*media.Info{clip(), clip(), silent},
+			incompatible: []int{2},
+			mismatched:   []string{"audio"},
+		},
+		{
+			name:         "tie goes to the earliest",
+			infos:        []*media.Info{uhd, clip()},
+			incompatible: []int{1},
+			mismatched:   []string{"size"},
+		},
+		{
+			name:         "probe failed",
+			infos:        []*media.Info{nil, clip()},
+			reference:    1,
+			incompatible: []int{0},
+			mismatched:   compatColumns,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := make([]media.Result, len(tt.infos))
+			for i, info := range tt.infos {
+				results[i] = media.Result{Path: string(rune('a'+i)) + ".mp4", Info: info}
+				if info == nil {
+					results[i].Err = errors.New("moov atom not found")
+				}
+			}
+			report := checkCompatibility(results)
+
+			if report.reference != tt.reference {
+				t.Errorf("reference = %d, want %d", report.reference, tt.reference)
+			}
+			if got := report.incompatible(); !slices.Equal(got, tt.incompatible) {
+				t.Errorf("incompatible = %v, want %v", got, tt.incompatible)
+			}
+			if report.compatible() != (len(tt.incompatible) == 0) {
+				t.Errorf("compatible = %v", report.compatible())
+			}
+			if len(tt.incompatible) > 0 {
+				var columns []string
+				for column, name := range compatColumns {
+					if report.mismatched(tt.incompatible[0], column) {
+						columns = append(columns, name)
+					}
+				}
+				if !slices.Equal(columns, tt.mismatched) {
+					t.Errorf("mismatched columns = %v, want %v", columns, tt.mismatched)
+				}
+			}
+		})
+	}
+}
+
+func TestCompatValues(t *testing.T) {
+	rotated := clip()
+	rotated.Video.Rotation = 270
+	rotated.Audio = []media.AudioStream{{Codec: "opus", Channels: 1, SampleRate: 24000}}
+	want := []string{"h264 High", "1920x1080 ↻270", "30", "1/15360", "yuv420p", "opus 1ch 24000Hz"}
+	if got := compatValues(rotated); !slices.Equal(got, want) {
+		t.Errorf("compatValues = %q, want %q", got, want)
+	}
+}
+
+func TestPlanNormalizeRotatedReference(t *testing.T) {
+	rotated := clip()
+	rotated.Video.Rotation = 90
+	report := checkCompatibility([]media.Result{
+		{Path: "a.mp4", Info: rotated},
+		{Path: "b.mp4", Info: rotated},
+		{Path: "c.mp4", Info: clip()},
+	})
+	_, err := planNormalize(report, "out.mkv")
+	if err == nil || !strings.Contains(err.Error(), "rotated 90°") {
+		t.Errorf("planNormalize err = %v, want a refusal to match rotated clips", err)
+	}
+}
+
+func TestPlanNormalizeSilentClip(t *testing.T) {
+	silent := clip()
+	silent.Audio = []media.AudioStream{}
+	report := checkCompatibility([]media.Result{
+		{Path: "a.mp4", Info: clip()},
+		{Path: "b.mp4", Info: silent},
+		{Path: "c.mp4", Info: clip()},
+	})
+	steps, err := planNormalize(report, "out.mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 1 || steps[0].index != 1 {
+		t.Fatalf("steps = %+v, want just clip 2", steps)
+	}
+
+	// Silence is borrowed from anullsrc and cut to the video's length
+	want := []string{
+		"-y", "-i", absPath(t, "b.mp4"),
+		"-f", "lavfi", "-i", "anullsrc=r=48000:cl=stereo",
+		"-map", "0:v:0", "-map", "1:a:0", "-shortest",
+		"-vf", "scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30",
+		"-c:v", "libx264", "-pix_fmt", "yuv420p", "-profile:v", "high", "-video_track_timescale", "15360",
+		"-c:a", "aac", "-ar", "48000", "-ac", "2",
+		filepath.Join(".out-normalized", "002-b.mp4"),
+	}
+	if got := steps[0].plan.args(); !slices.Equal(got, want) {
+		t.Errorf("args =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Preset is a named way to encode the combined video - pick one with --preset! 🎨
+type Preset struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Copy concatenates with the concat demuxer and `-c copy` instead of
+	// re-encoding, so none of the codec settings below apply
+	Copy bool `yaml:"copy,omitempty"`
+
+	VideoEncoder `yaml:",inline"`
+	// Fallbacks are tried in order when the encoder above isn't available
+	// on this machine (like NVENC without an NVIDIA GPU)
+	Fallbacks []VideoEncoder `yaml:"fallbacks,omitempty"`
+
+	AudioCodec    string `yaml:"audioCodec,omitempty"`    // e.g. aac, pcm_s16le
+	AudioBitrate  string `yaml:"audioBitrate,omitempty"`  // -b:a, e.g. 160k
+	AudioRate     int    `yaml:"audioRate,omitempty"`     // -ar (default 48000)
+	AudioChannels int    `yaml:"audioChannels,omitempty"` // -ac (default 2)
+
+	Container string   `yaml:"container,omitempty"` // Output format for -f (ffmpeg guesses from the extension if empty)
+	ExtraArgs []string `yaml:"extraArgs,omitempty"` // Output arguments, e.g. ["-movflags", "+faststart"]
+	Extension string   `yaml:"extension,omitempty"` // Default output extension, e.g. .mp4
+}
+
+// VideoEncoder is a video encoder and its settings
+type VideoEncoder struct {
+	VideoCodec string   `yaml:"videoCodec,omitempty"` // e.g. hevc_nvenc, libx264, prores_ks
+	Profile    string   `yaml:"profile,omitempty"`    // -profile:v
+	PixFmt     string   `yaml:"pixFmt,omitempty"`     // -pix_fmt
+	Quality    string   `yaml:"quality,omitempty"`    // Quality flag and value, e.g. "-crf 20" or "-cq 22"
+	VideoArgs  []string `yaml:"videoArgs,omitempty"`  // Any other video encoder arguments
+}
+
+// MegaCombineConfig holds mega-combine's settings in config.yml
+type MegaCombineConfig struct {
+	Presets []Preset `yaml:"presets,omitempty"` // Extra presets (or replacements for the built-in ones)
+}
+
+// builtinPresets are the three modes mega-combine has always had 💕
+var builtinPresets = []Preset{
+	{
+		Name:        "fast",
+		Description: "Concat demuxer with -c copy - no re-encoding, so fast! (the default)",
+		Copy:        true,
+		Extension:   ".mkv",
+	},
+	{
+		Name:        "slowbutsmall",
+		Description: "GPU-accelerated H.265 with NVENC, 10-bit, AAC audio - much smaller than ProRes (--slowbutsmall)",
+		VideoEncoder: VideoEncoder{
+			VideoCodec: "hevc_nvenc",
+			Profile:    "main10",
+			PixFmt:     "p010le",
+			Quality:    "-cq 22", // Constant quality level (lower = higher quality, 18-28 range)
+			VideoArgs: []string{
+				"-preset", "p6", // Quality preset (p1=fastest, p7=slowest/highest quality)
+				"-tune", "hq", // High quality tuning
+				"-rc", "vbr_hq", // High quality variable bitrate
+				"-b:v", "0", // Bitrate 0 when using CQ mode
+				"-maxrate", "0", // Max rate 0 when using CQ mode
+			},
+		},
+		// No NVIDIA GPU? Software H.265 at about the same quality, then H.264
+		Fallbacks: []VideoEncoder{
+			{
+				VideoCodec: "libx265",
+				Profile:    "main10",
+				PixFmt:     "yuv420p10le",
+				Quality:    "-crf 22", // CRF lines up with NVENC's CQ closely enough
+				VideoArgs:  []string{"-preset", "slow", "-tag:v", "hvc1"},
+			},
+			{
+				VideoCodec: "libx264",
+				Profile:    "high",
+				PixFmt:     "yuv420p", // 8-bit - plenty of libx264 builds can't do 10-bit
+				Quality:    "-crf 20", // H.264 needs a little more to look as good
+				VideoArgs:  []string{"-preset", "slow"},
+			},
+		},
+		AudioCodec:   "aac",
+		AudioBitrate: "160k",
+		ExtraArgs:    []string{"-movflags", "+faststart"}, // Fast start for web streaming
+		Extension:    ".mp4",
+	},
+	{
+		Name:        "waytoobig",
+		Description: "ProRes LT 10-bit 4:2:2 with PCM audio for DaVinci Resolve on iPad - way too big! (--waytoobig)",
+		VideoEncoder: VideoEncoder{
+			VideoCodec: "prores_ks",
+			Profile:    "1",
+			PixFmt:     "yuv422p10le",
+			VideoArgs:  []string{"-threads", "0"},
+		},
+		AudioCodec: "pcm_s16le",
+		Extension:  ".mov",
+	},
+}
+
+// loadPresets returns the built-in presets plus the ones from config.yml,
+// where a preset with a built-in's name replaces it
+func loadPresets() ([]Preset, error) {
+	presets := append([]Preset(nil), builtinPresets...)
+	config, err := LoadConfig()
+	if errors.Is(err, fs.ErrNotExist) {
+		return presets, nil // No config.yml is fine - the built-ins still work
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, preset := range config.MegaCombine.Presets {
+		if err := preset.validate(); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(preset.Extension, ".") {
+			preset.Extension = "." + preset.Extension
+		}
+		replaced := false
+		for i := range presets {
+			if presets[i].Name == preset.Name {
+				presets[i] = preset
+				replaced = true
+			}
+		}
+		if !replaced {
+			presets = append(presets, preset)
+		}
+	}
+	return presets, nil
+}
+
+// validate catches presets that can't work before ffmpeg does
+func (p Preset) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("mega-combine preset without a name in config.yml")
+	}
+	if !p.Copy && p.VideoCodec == "" {
+		return fmt.Errorf("mega-combine preset %q needs a videoCodec (or copy: true)", p.Name)
+	}
+	if p.Extension == "" {
+		return fmt.Errorf("mega-combine preset %q needs an extension, like .mp4", p.Name)
+	}
+	for _, fallback := range p.Fallbacks {
+		if fallback.VideoCodec == "" {
+			return fmt.Errorf("mega-combine preset %q: every fallback needs a videoCodec", p.Name)
+		}
+	}
+	for _, encoder := range p.encoders() {
+		if encoder.Quality != "" && !strings.HasPrefix(encoder.Quality, "-") {
+			return fmt.Errorf("mega-combine preset %q: quality should be a flag and value, like \"-crf 20\"", p.Name)
+		}
+	}
+	return nil
+}
+
+// encoders is the preset's encoder followed by its fallbacks, in the order to try them
+func (p Preset) encoders() []VideoEncoder {
+	return append([]VideoEncoder{p.VideoEncoder}, p.Fallbacks...)
+}
+
+// findPreset looks a preset up by name
+func findPreset(presets []Preset, name string) (Preset, error) {
+	var names []string
+	for _, preset := range presets {
+		if preset.Name == name {
+			return preset, nil
+		}
+		names = append(names, preset.Name)
+	}
+	return Preset{}, fmt.Errorf("unknown preset %q (have %s - see `marcli mega-combine presets`)", name, strings.Join(names, ", "))
+}
+
+// codecArgs are the encoder arguments for a re-encoding preset, one group per preview line
+func (p Preset) codecArgs() [][]string {
+	video := []string{"-c:v", p.VideoCodec}
+	video = append(video, p.VideoArgs...)
+	video = append(video, strings.Fields(p.Quality)...)
+	var format []string
+	if p.PixFmt != "" {
+		format = append(format, "-pix_fmt", p.PixFmt)
+	}
+	if p.Profile != "" {
+		format = append(format, "-profile:v", p.Profile)
+	}
+
+	audio := []string{"-c:a", p.AudioCodec}
+	if p.AudioCodec == "" {
+		audio[1] = "aac"
+	}
+	if p.AudioBitrate != "" {
+		audio = append(audio, "-b:a", p.AudioBitrate)
+	}
+	audio = append(audio, "-ar", strconv.Itoa(orDefault(p.AudioRate, 48000)), "-ac", strconv.Itoa(orDefault(p.AudioChannels, 2)))
+
+	groups := [][]string{video}
+	if len(format) > 0 {
+		groups = append(groups, format)
+	}
+	groups = append(groups, audio)
+	if len(p.ExtraArgs) > 0 {
+		groups = append(groups, p.ExtraArgs)
+	}
+	if p.Container != "" {
+		groups = append(groups, []string{"-f", p.Container})
+	}
+	return groups
+}
+
+// orDefault returns value, or fallback when it isn't set
+func orDefault(value, fallback int) int {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+// describe lists a preset's settings for `mega-combine presets`
+func (p Preset) describe() string {
+	if p.Copy {
+		return fmt.Sprintf("copy streams as-is → %s", p.Extension)
+	}
+	var parts []string
+	for _, group := range p.codecArgs() {
+		parts = append(parts, strings.Join(group, " "))
+	}
+	description := fmt.Sprintf("%s → %s", strings.Join(parts, " "), p.Extension)
+	for _, fallback := range p.Fallbacks {
+		args := append([]string{"-c:v", fallback.VideoCodec}, fallback.VideoArgs...)
+		args = append(args, strings.Fields(fallback.Quality)...)
+		description += "\n    fallback: " + strings.Join(args, " ")
+	}
+	return description
+}
+
+// listPresets prints every preset for `marcli mega-combine presets` 🎀
+func listPresets() (string, error) {
+	presets, err := loadPresets()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("mega-combine presets (pick one with --preset <name>):\n")
+	for _, preset := range presets {
+		fmt.Fprintf(&b, "\n  %s - %s\n    %s\n", preset.Name, preset.Description, preset.describe())
+	}
+	b.WriteString("\nAdd your own under megaCombine.presets in config.yml 💕\n")
+	return b.String(), nil
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadPresets(t *testing.T) {
+	names := func(presets []Preset) []string {
+		var names []string
+		for _, preset := range presets {
+			names = append(names, preset.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name   string
+		config string // "" means no config.yml at all
+		names  []string
+		err    string
+	}{
+		{
+			name:  "no config",
+			names: []string{"fast", "slowbutsmall", "waytoobig"},
+		},
+		{
+			name:   "config without presets",
+			config: "version: 1.0.0\nbuild: 3\n",
+			names:  []string{"fast", "slowbutsmall", "waytoobig"},
+		},
+		{
+			name: "added and replaced",
+			config: `megaCombine:
+  presets:
+    - name: phone
+      videoCodec: libx264
+      quality: -crf 23
+      extension: mp4
+    - name: fast
+      copy: true
+      extension: .mp4
+`,
+			names: []string{"fast", "slowbutsmall", "waytoobig", "phone"},
+		},
+		{
+			name:   "broken yaml",
+			config: "megaCombine: [oops\n",
+			err:    "failed to parse config file",
+		},
+		{
+			name:   "invalid preset",
+			config: "megaCombine:\n  presets:\n    - name: nocodec\n      extension: .mp4\n",
+			err:    `preset "nocodec" needs a videoCodec`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if tt.config != "" {
+				if err := os.WriteFile(configFile, []byte(tt.config), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			presets, err := loadPresets()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(presets); !slices.Equal(got, tt.names) {
+				t.Errorf("presets = %v, want %v", got, tt.names)
+			}
+			for _, preset := range presets {
+				if !strings.HasPrefix(preset.Extension, ".") {
+					t.Errorf("preset %s extension = %q, want a leading dot", preset.Name, preset.Extension)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadPresetsReplacesBuiltin(t *testing.T) {
+	t.Chdir(t.TempDir())
+	os.WriteFile(configFile, []byte("megaCombine:\n  presets:\n    - name: fast\n      copy: true\n      extension: .mp4\n"), 0644)
+
+	presets, err := loadPresets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := findPreset(presets, "fast")
+	if err != nil || fast.Extension != ".mp4" {
+		t.Errorf("fast = %+v, %v, want the config's .mp4 version", fast, err)
+	}
+	if builtinPresets[0].Extension != ".mkv" {
+		t.Error("loading presets changed the built-in ones")
+	}
+}
+
+func TestPresetValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		preset Preset
+		err    string
+	}{
+		{
+			name:   "copy",
+			preset: Preset{Name: "copy", Copy: true, Extension: ".mkv"},
+		},
+		{
+			name:   "re-encode with fallbacks",
+			preset: Preset{Name: "x", VideoEncoder: VideoEncoder{VideoCodec: "hevc_nvenc", Quality: "-cq 22"}, Fallbacks: []VideoEncoder{{VideoCodec: "libx265", Quality: "-crf 22"}}, Extension: ".mp4"},
+		},
+		{
+			name:   "no name",
+			preset: Preset{Copy: true, Extension: ".mkv"},
+			err:    "without a name",
+		},
+		{
+			name:   "no codec",
+			preset: Preset{Name: "x", Extension: ".mp4"},
+			err:    "needs a videoCodec",
+		},
+		{
+			name:   "no extension",
+			preset: Preset{Name: "x", VideoEncoder: VideoEncoder{VideoCodec: "libx264"}},
+			err:    "needs an extension",
+		},
+		{
+			name:   "fallback without codec",
+			preset: Preset{Name: "x", VideoEncoder: VideoEncoder{VideoCodec: "libx264"}, Fallbacks: []VideoEncoder{{Quality: "-crf 20"}}, Extension: ".mp4"},
+			err:    "every fallback needs a videoCodec",
+		},
+		{
+			name:   "quality without a flag",
+			preset: Preset{Name: "x", VideoEncoder: VideoEncoder{VideoCodec: "libx264", Quality: "20"}, Extension: ".mp4"},
+			err:    "quality should be a flag and value",
+		},
+		{
+			name:   "fallback quality without a flag",
+			preset: Preset{Name: "x", VideoEncoder: VideoEncoder{VideoCodec: "libx264"}, Fallbacks: []VideoEncoder{{VideoCodec: "mpeg4", Quality: "5"}}, Extension: ".mp4"},
+			err:    "quality should be a flag and value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.preset.validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("validate = %v, want ok", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("validate = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	for _, preset := range builtinPresets {
+		if err := preset.validate(); err != nil {
+			t.Errorf("built-in preset %s: %v", preset.Name, err)
+		}
+	}
+}
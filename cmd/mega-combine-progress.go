@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"marcli/media"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-isatty"
+)
+
+// progressClip is one input of a plan, so the progress bar can mark where each clip starts
+type progressClip struct {
+	name    string
+	seconds float64 // 0 if it couldn't be probed
+}
+
+// progressClips pairs the files with their probed durations
+func progressClips(files []string, infos []*media.Info) []progressClip {
+	clips := make([]progressClip, len(files))
+	for i, file := range files {
+		clips[i].name = filepath.Base(file)
+		if i < len(infos) && infos[i] != nil {
+			clips[i].seconds = infos[i].Duration
+		}
+	}
+	return clips
+}
+
+// durations lists the clips' durations, or nothing if any is unknown - a
+// percentage that's wrong is worse than none
+func durations(clips []progressClip) []float64 {
+	seconds := make([]float64, len(clips))
+	for i, clip := range clips {
+		if clip.seconds <= 0 {
+			return nil
+		}
+		seconds[i] = clip.seconds
+	}
+	return seconds
+}
+
+// Progress bar styles 🎀
+var (
+	barFilledStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("212"))
+	barEmptyStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+	barMarkerStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("219")).Bold(true)
+	progressDim    = lipgloss.NewStyle().Foreground(lipgloss.Color("245"))
+)
+
+// progressMsg is a progress update from ffmpeg
+type progressMsg media.Progress
+
+// ffmpegDoneMsg says ffmpeg has exited
+type ffmpegDoneMsg struct{ err error }
+
+// progressModel draws a running ffmpeg as a progress bar, with a marker
+// where each clip starts - so much cuter than ffmpeg's stats line! 💖
+type progressModel struct {
+	output   string
+	clips    []progressClip
+	progress media.Progress
+	started  bool // Got the first update
+	width    int
+	stop     func() // Asks ffmpeg to stop
+	stopping bool
+	done     bool
+	err      error
+}
+
+func (m *progressModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *progressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		// ffmpeg can't read 'q' while we have the keyboard, so we stop it for it
+		if s := msg.String(); (s == "q" || s == "ctrl+c") && !m.stopping {
+			m.stopping = true
+			m.stop()
+		}
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case progressMsg:
+		m.progress = media.Progress(msg)
+		m.started = true
+	case ffmpegDoneMsg:
+		m.done, m.err = true, msg.err
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m *progressModel) View() string {
+	p := m.progress
+	var b strings.Builder
+
+	// Which clip we're on
+	title := "🎬 " + filepath.Base(m.output)
+	if p.Clips > 1 && p.Clip > 0 {
+		title += progressDim.Render(fmt.Sprintf(" · clip %d/%d %s", p.Clip, p.Clips, m.clips[p.Clip-1].name))
+	}
+	b.WriteString(title + "\n")
+
+	if p.Total > 0 {
+		b.WriteString(m.bar() + fmt.Sprintf(" %5.1f%%\n", p.Percent))
+	}
+
+	stats := []string{media.FormatDuration(p.Seconds)}
+	if p.Total > 0 {
+		stats[0] += " / " + media.FormatDuration(p.Total)
+	}
+	if p.Speed > 0 {
+		stats = append(stats, fmt.Sprintf("%.2fx", p.Speed))
+	}
+	stats = append(stats, media.FormatSize(p.Size))
+	if p.ETA > 0 {
+		stats = append(stats, "ETA "+media.FormatDuration(p.ETA))
+	}
+	switch {
+	case m.done && m.err == nil:
+		stats = append(stats, "done! ✨")
+	case m.done && m.stopping:
+		stats = append(stats, "stopped")
+	case m.done:
+		stats = append(stats, "failed 💔")
+	case m.stopping:
+		stats = append(stats, "stopping...")
+	case !m.started:
+		stats = append(stats, "starting...")
+	default:
+		stats = append(stats, "q to stop")
+	}
+	b.WriteString(progressDim.Render(strings.Join(stats, " · ")) + "\n")
+	return b.String()
+}
+
+// bar draws the progress bar with a marker where each clip after the first starts
+func (m *progressModel) bar() string {
+	width := 40
+	if m.width > 0 {
+		width = max(10, min(60, m.width-10))
+	}
+	filled := int(m.progress.Percent / 100 * float64(width))
+
+	markers := make(map[int]bool)
+	start := 0.0
+	for _, clip := range m.clips[:max(0, len(m.clips)-1)] {
+		start += clip.seconds
+		markers[int(start/m.progress.Total*float64(width))] = true
+	}
+
+	var b strings.Builder
+	for i := 0; i < width; i++ {
+		switch {
+		case markers[i] && i > 0:
+			b.WriteString(barMarkerStyle.Render("┃"))
+		case i < filled:
+			b.WriteString(barFilledStyle.Render("█"))
+		default:
+			b.WriteString(barEmptyStyle.Render("░"))
+		}
+	}
+	return b.String()
+}
+
+// progressInterval is how often progress lines are printed without a terminal
+// when the total duration isn't known
+const progressInterval = 10 * time.Second
+
+// runFFmpeg runs ffmpeg with -progress, drawing a progress bar in a terminal
+// or printing progress lines (for logs and the job queue) without one
+func runFFmpeg(args []string, output string, clips []progressClip) error {
+	// -progress swaps ffmpeg's stats line for key=value updates on stdout
+	cmd := exec.Command(media.FFmpeg, append([]string{"-nostats", "-progress", "pipe:1"}, args...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		printProgress(stdout, clips)
+		return cmd.Wait()
+	}
+
+	// ffmpeg's messages would scribble over the bar, so keep the last few for errors
+	stderr := &tailWriter{max: 20}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	model := &progressModel{output: output, clips: clips, stop: func() { interrupt(cmd.Process) }}
+	program := tea.NewProgram(model)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		media.ReadProgress(stdout, durations(clips), func(p media.Progress) {
+			program.Send(progressMsg(p))
+		})
+	}()
+	go func() {
+		wg.Wait() // Every update is in before we say it's done
+		program.Send(ffmpegDoneMsg{err: cmd.Wait()})
+	}()
+
+	if _, err := program.Run(); err != nil {
+		interrupt(cmd.Process)
+		return err
+	}
+	if model.err != nil && model.stopping {
+		return fmt.Errorf("stopped - %s may be incomplete", output)
+	}
+	if model.err != nil {
+		fmt.Fprint(os.Stderr, stderr.String())
+	}
+	return model.err
+}
+
+// printProgress prints a progress line whenever the percentage or clip
+// changes (or every so often if there's no percentage)
+func printProgress(r io.Reader, clips []progressClip) {
+	var last media.Progress
+	var lastPrinted time.Time
+	media.ReadProgress(r, durations(clips), func(p media.Progress) {
+		changed := int(p.Percent) != int(last.Percent) || p.Clip != last.Clip || p.Done
+		if changed || (p.Total == 0 && time.Since(lastPrinted) >= progressInterval) {
+			fmt.Fprintln(os.Stderr, p.String())
+			last, lastPrinted = p, time.Now()
+		}
+	})
+}
+
+// interrupt asks ffmpeg to stop like Ctrl+C would, so it still finishes the
+// file - Windows can't, so it's killed
+func interrupt(p *os.Process) {
+	if err := p.Signal(os.Interrupt); err != nil {
+		p.Kill()
+	}
+}
+
+// isTerminal reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// tailWriter keeps the last max lines written to it
+type tailWriter struct {
+	mu      sync.Mutex
+	max     int
+	lines   []string
+	partial string
+}
+
+func (t *tailWriter) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := strings.Split(t.partial+strings.ReplaceAll(string(b), "\r", "\n"), "\n")
+	t.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if line != "" {
+			t.lines = append(t.lines, line)
+		}
+	}
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+	return len(b), nil
+}
+
+// String returns the kept lines
+func (t *tailWriter) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := t.lines
+	if t.partial != "" {
+		lines = append(lines, t.partial)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileSelection is how mega-combine picks videos without the TUI - so scriptable! 🤖
+type fileSelection struct {
+	files    []string  // Files named on the command line, in order ("-" reads a list from stdin)
+	globs    []string  // --glob patterns, matches sorted oldest first
+	fromFile string    // --from-file list, one path per line ("-" for stdin)
+	all      bool      // --all: every video in the current directory
+	since    time.Time // --since: only files modified at or after this
+	until    time.Time // --until: only files modified at or before this
+}
+
+// selectionFromContext reads the selection flags main.go parsed into the context 💕
+func selectionFromContext(ctx context.Context) (fileSelection, error) {
+	var s fileSelection
+	s.files, _ = ctx.Value("megaCombineFiles").([]string)
+	s.globs, _ = ctx.Value("megaCombineGlobs").([]string)
+	s.fromFile, _ = ctx.Value("megaCombineFromFile").(string)
+	s.all = ctx.Value("megaCombineAll") == true
+
+	var err error
+	if since, ok := ctx.Value("megaCombineSince").(string); ok {
+		if s.since, err = parseTimeFlag(since); err != nil {
+			return s, fmt.Errorf("invalid --since: %w", err)
+		}
+	}
+	if until, ok := ctx.Value("megaCombineUntil").(string); ok {
+		if s.until, err = parseTimeFlag(until); err != nil {
+			return s, fmt.Errorf("invalid --until: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// parseTimeFlag understands dates like 2024-05-01, times like "2024-05-01 14:30",
+// RFC 3339, and durations like 36h meaning "that long ago" - so flexible! ✨
+func parseTimeFlag(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q isn't a date (2006-01-02), time (2006-01-02 15:04) or duration (36h)", value)
+}
+
+// active reports whether any selection flag was given, meaning no TUI 💅
+func (s fileSelection) active() bool {
+	return len(s.files) > 0 || len(s.globs) > 0 || s.fromFile != "" || s.all || !s.since.IsZero() || !s.until.IsZero()
+}
+
+// resolve works out the files to combine: named files first in the order given,
+// then glob matches and --all, both oldest first like the picker. Duplicates are
+// dropped and the time filters apply to everything.
+func (s fileSelection) resolve() ([]string, error) {
+	var named []string
+	for _, file := range s.files {
+		if file == "-" {
+			list, err := readFileList(os.Stdin)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read file list from stdin: %w", err)
+			}
+			named = append(named, list...)
+			continue
+		}
+		named = append(named, file)
+	}
+	if s.fromFile != "" {
+		list, err := readFileListFrom(s.fromFile)
+		if err != nil {
+			return nil, err
+		}
+		named = append(named, list...)
+	}
+
+	// Named files must exist and be videos - a typo shouldn't just vanish
+	var items []videoFileItem
+	for _, file := range named {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() || !isVideoFile(file) {
+			return nil, fmt.Errorf("%s is not a video file", file)
+		}
+		items = append(items, videoFileItem{title: filepath.Base(file), filePath: file, modTime: info.ModTime(), size: info.Size()})
+	}
+
+	// Glob matches skip anything that isn't a video, just like the picker
+	var matched []videoFileItem
+	for _, pattern := range s.globs {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --glob %q: %w", pattern, err)
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() || !isVideoFile(path) {
+				continue
+			}
+			matched = append(matched, videoFileItem{title: filepath.Base(path), filePath: path, modTime: info.ModTime(), size: info.Size()})
+		}
+	}
+
+	// A file list that turns out empty is a mistake, not a request for everything
+	sources := len(s.files) > 0 || len(s.globs) > 0 || s.fromFile != ""
+	if sources && len(named) == 0 && len(s.globs) == 0 && !s.all {
+		return nil, fmt.Errorf("no files selected - the file list is empty")
+	}
+
+	// --all, or a time filter on its own, means every video in the current directory
+	onlyFilters := !sources && (!s.since.IsZero() || !s.until.IsZero())
+	if s.all || onlyFilters {
+		all, err := getVideoFiles(".")
+		if err != nil {
+			return nil, err
+		}
+		matched = append(matched, all...)
+	}
+	sortByModTime(matched)
+	items = append(items, matched...)
+
+	seen := make(map[string]bool)
+	var files []string
+	for _, item := range items {
+		if !s.since.IsZero() && item.modTime.Before(s.since) {
+			continue
+		}
+		if !s.until.IsZero() && item.modTime.After(s.until) {
+			continue
+		}
+		key := item.filePath
+		if abs, err := filepath.Abs(item.filePath); err == nil {
+			key = abs
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		files = append(files, item.filePath)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no video files matched")
+	}
+	return files, nil
+}
+
+// readFileListFrom reads a file list from a path, or from stdin for "-" 📝
+func readFileListFrom(path string) ([]string, error) {
+	if path == "-" {
+		list, err := readFileList(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file list from stdin: %w", err)
+		}
+		return list, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file list: %w", err)
+	}
+	defer f.Close()
+	list, err := readFileList(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file list: %w", err)
+	}
+	return list, nil
+}
+
+// readFileList reads one path per line, skipping blank lines and # comments
+func readFileList(r io.Reader) ([]string, error) {
+	var files []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		files = append(files, line)
+	}
+	return files, scanner.Err()
+}
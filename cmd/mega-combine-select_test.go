@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeFlag(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)},
+		{"2024-05-01 14:30", time.Date(2024, 5, 1, 14, 30, 0, 0, time.Local)},
+		{"2024-05-01T14:30", time.Date(2024, 5, 1, 14, 30, 0, 0, time.Local)},
+		{"2024-05-01 14:30:15", time.Date(2024, 5, 1, 14, 30, 15, 0, time.Local)},
+		{"2024-05-01T14:30:00Z", time.Date(2024, 5, 1, 14, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeFlag(tt.value)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseTimeFlag(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+
+	// Durations mean that long ago
+	got, err := parseTimeFlag("36h")
+	if err != nil || time.Since(got).Round(time.Minute) != 36*time.Hour {
+		t.Errorf("parseTimeFlag(36h) = %v, %v, want 36 hours ago", got, err)
+	}
+
+	if _, err := parseTimeFlag("last tuesday"); err == nil {
+		t.Error("parseTimeFlag accepted \"last tuesday\"")
+	}
+}
+
+func TestSelectionResolve(t *testing.T) {
+	// a is the oldest and c the newest, so they sort a, b, c
+	dir := t.TempDir()
+	t.Chdir(dir)
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	for i, name := range []string{"a.mp4", "b.MOV", "c.mkv", "notes.txt"} {
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := day.Add(time.Duration(i) * 24 * time.Hour)
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir("clips.mp4", 0755); err != nil {
+		t.Fatal(err)
+	}
+	list := filepath.Join(dir, "list.txt")
+	os.WriteFile(list, []byte("# the good ones\nc.mkv\n\n  a.mp4  \n"), 0644)
+	empty := filepath.Join(dir, "empty.txt")
+	os.WriteFile(empty, []byte("# nothing yet\n"), 0644)
+
+	tests := []struct {
+		name      string
+		selection fileSelection
+		want      []string
+		err       string
+	}{
+		{
+			name:      "named files keep their order",
+			selection: fileSelection{files: []string{"c.mkv", "a.mp4"}},
+			want:      []string{"c.mkv", "a.mp4"},
+		},
+		{
+			name:      "glob matches oldest first",
+			selection: fileSelection{globs: []string{"*"}},
+			want:      []string{"a.mp4", "b.MOV", "c.mkv"},
+		},
+		{
+			name:      "all",
+			selection: fileSelection{all: true},
+			want:      []string{"a.mp4", "b.MOV", "c.mkv"},
+		},
+		{
+			name:      "named first, then the rest without duplicates",
+			selection: fileSelection{files: []string{"c.mkv", "./c.mkv"}, all: true},
+			want:      []string{"c.mkv", "a.mp4", "b.MOV"},
+		},
+		{
+			name:      "file list",
+			selection: fileSelection{fromFile: list},
+			want:      []string{"c.mkv", "a.mp4"},
+		},
+		{
+			name:      "time filters on their own pick from the folder",
+			selection: fileSelection{since: day.Add(12 * time.Hour)},
+			want:      []string{"b.MOV", "c.mkv"},
+		},
+		{
+			name:      "time filters apply to named files too",
+			selection: fileSelection{files: []string{"c.mkv", "a.mp4"}, until: day.Add(36 * time.Hour)},
+			want:      []string{"a.mp4"},
+		},
+		{
+			name:      "missing file",
+			selection: fileSelection{files: []string{"a.mp4", "nope.mp4"}},
+			err:       "nope.mp4",
+		},
+		{
+			name:      "not a video",
+			selection: fileSelection{files: []string{"notes.txt"}},
+			err:       "notes.txt is not a video file",
+		},
+		{
+			name:      "directory",
+			selection: fileSelection{files: []string{"clips.mp4"}},
+			err:       "clips.mp4 is not a video file",
+		},
+		{
+			name:      "empty file list",
+			selection: fileSelection{fromFile: empty},
+			err:       "the file list is empty",
+		},
+		{
+			name:      "empty file list with time filters",
+			selection: fileSelection{fromFile: empty, since: day},
+			err:       "the file list is empty",
+		},
+		{
+			name:      "glob matching nothing",
+			selection: fileSelection{globs: []string{"*.webm"}},
+			err:       "no video files matched",
+		},
+		{
+			name:      "bad glob",
+			selection: fileSelection{globs: []string{"[a"}},
+			err:       "invalid --glob",
+		},
+		{
+			name:      "filtered to nothing",
+			selection: fileSelection{all: true, until: day.Add(-time.Hour)},
+			err:       "no video files matched",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.selection.resolve()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
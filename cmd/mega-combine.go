@@ -2,14 +2,16 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"marcli/media"
 	"marcli/ui"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -18,10 +20,14 @@ import (
 
 // videoFileItem represents a video file in the list
 type videoFileItem struct {
-	title    string
-	filePath string
-	modTime  time.Time
-	selected bool
+	title     string
+	filePath  string
+	modTime   time.Time
+	size      int64
+	selected  bool
+	nameWidth int         // Width of the name column, so the columns line up 💅
+	probed    bool        // Whether ffprobe has answered yet
+	info      *media.Info // What ffprobe found (nil if it couldn't tell us)
 }
 
 func (i videoFileItem) FilterValue() string {
@@ -38,17 +44,103 @@ func (i *videoFileItem) SetSelected(selected bool) {
 
 func (i videoFileItem) DisplayText() string {
 	dateStr := i.modTime.Format("2006-01-02 15:04")
-	return fmt.Sprintf("%s  %s", i.title, dateStr)
+	// Pad by runes, not bytes, so names with emoji still line up
+	title := []rune(i.title)
+	if i.nameWidth > 0 && len(title) > i.nameWidth {
+		title = append(title[:i.nameWidth-1], '…')
+	}
+	padding := strings.Repeat(" ", max(0, i.nameWidth-len(title)))
+	return fmt.Sprintf("%s%s  %s  %s", string(title), padding, dateStr, i.probeColumns())
+}
+
+// probeColumns shows duration, resolution, frame rate, codec and audio from ffprobe 🔍
+func (i videoFileItem) probeColumns() string {
+	if !i.probed {
+		return "…"
+	}
+	if i.info == nil {
+		return "?"
+	}
+	audio := "no audio"
+	if i.info.HasAudio() {
+		a := i.info.Audio[0]
+		audio = fmt.Sprintf("%s %dch", a.Codec, a.Channels)
+		if len(i.info.Audio) > 1 {
+			audio += fmt.Sprintf(" +%d", len(i.info.Audio)-1)
+		}
+	}
+	if i.info.Video == nil {
+		return fmt.Sprintf("%7s  %-9s  %8s  %-6s  %s", media.FormatDuration(i.info.Duration), "no video", "", "", audio)
+	}
+	v := i.info.Video
+	return fmt.Sprintf("%7s  %-9s  %5sfps  %-6s  %s", media.FormatDuration(i.info.Duration), v.Resolution(), media.FormatFrameRate(v.FrameRate), v.Codec, audio)
+}
+
+// maxNameWidth caps the name column so the probe columns still fit
+const maxNameWidth = 32
+
+// probeDoneMsg carries ffprobe's answer for one item in the picker
+type probeDoneMsg struct {
+	index int
+	info  *media.Info
+	err   error
+}
+
+// probeItem probes one file in the background, a few at a time, until ctx is
+// cancelled when the picker closes
+func probeItem(ctx context.Context, index int, path string, sem chan struct{}) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return probeDoneMsg{index: index, err: ctx.Err()}
+		}
+		defer func() { <-sem }()
+		info, err := media.Probe(ctx, path)
+		return probeDoneMsg{index: index, info: info, err: err}
+	}
+}
+
+// selectionStatus sums up the selection for the picker's status line - so informative! 💖
+func selectionStatus(selected []ui.SelectableItem) string {
+	if len(selected) == 0 {
+		return "Nothing selected yet"
+	}
+	var duration float64
+	var size int64
+	pending := false
+	for _, item := range selected {
+		videoItem, ok := item.(*videoFileItem)
+		if !ok {
+			continue
+		}
+		size += videoItem.size
+		if videoItem.info != nil {
+			duration += videoItem.info.Duration
+		} else if !videoItem.probed {
+			pending = true
+		}
+	}
+	durationStr := media.FormatDuration(duration)
+	if pending {
+		durationStr += "…"
+	}
+	return fmt.Sprintf("%d selected · %s · %s", len(selected), durationStr, media.FormatSize(size))
 }
 
 // megaCombineModel manages the state of the mega-combine TUI
 type megaCombineModel struct {
 	listModel     *ui.Model
 	items         []*videoFileItem
-	selectedFiles []string // Store selected file paths for return
+	arrange       *arrangeModel   // The arrange step, once more than one file is picked
+	height        int             // Terminal height, for the arrange step
+	selectedFiles []string        // The files to combine, in their final order
+	cancelled     bool            // Ctrl+C in the arrange step
+	probeSem      chan struct{}   // Limits how many ffprobes run at once
+	probeCtx      context.Context // Cancelled once the picker closes, stopping the probes
 }
 
-func initialMegaCombineModel() (megaCombineModel, error) {
+func initialMegaCombineModel(ctx context.Context) (megaCombineModel, error) {
 	// Get video files from current directory
 	items, err := getVideoFiles(".")
 	if err != nil {
@@ -59,10 +151,18 @@ func initialMegaCombineModel() (megaCombineModel, error) {
 		return megaCombineModel{}, fmt.Errorf("no video files found in current directory")
 	}
 
+	// Line the columns up on the longest name (within reason)
+	nameWidth := 0
+	for _, item := range items {
+		nameWidth = max(nameWidth, utf8.RuneCountInString(item.title))
+	}
+	nameWidth = min(nameWidth, maxNameWidth)
+
 	// Convert to pointers and SelectableItem interface
 	itemPtrs := make([]*videoFileItem, len(items))
 	selectableItems := make([]ui.SelectableItem, len(items))
 	for i := range items {
+		items[i].nameWidth = nameWidth
 		itemPtrs[i] = &items[i]
 		selectableItems[i] = itemPtrs[i]
 	}
@@ -71,74 +171,132 @@ func initialMegaCombineModel() (megaCombineModel, error) {
 	listModel := ui.New(ui.Config{
 		Title:    "Select Video Files",
 		Items:    selectableItems,
-		Width:    80,
+		Width:    120,
 		Height:   ui.DefaultListHeight,
 		HelpText: "Space: toggle, Enter: confirm, Ctrl+C: quit",
+		Status:   selectionStatus,
 	})
 
 	return megaCombineModel{
 		listModel: listModel,
 		items:     itemPtrs,
+		probeSem:  make(chan struct{}, media.Concurrency),
+		probeCtx:  ctx,
 	}, nil
 }
 
 func (m *megaCombineModel) Init() tea.Cmd {
-	return m.listModel.Init()
+	// Probe every file in the background so the picker shows up right away
+	cmds := []tea.Cmd{m.listModel.Init()}
+	for i, item := range m.items {
+		cmds = append(cmds, probeItem(m.probeCtx, i, item.filePath, m.probeSem))
+	}
+	return tea.Batch(cmds...)
 }
 
 func (m *megaCombineModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	// Fill in the columns as ffprobe answers
+	if probed, ok := msg.(probeDoneMsg); ok {
+		item := m.items[probed.index]
+		item.probed = true
+		item.info = probed.info
+		if probed.err != nil && !errors.Is(probed.err, media.ErrFFprobeNotFound) {
+			logger.Debug("Failed to probe video", "file", item.filePath, "err", probed.err)
+		}
+		return m, nil
+	}
+
+	if size, ok := msg.(tea.WindowSizeMsg); ok {
+		m.height = size.Height
+	}
+
+	// Second step: arranging the picked clips
+	if m.arrange != nil {
+		if _, ok := msg.(tea.WindowSizeMsg); ok {
+			m.listModel.Update(msg) // Still sized right if we go back
+		}
+		m.arrange.Update(msg)
+		switch {
+		case m.arrange.cancelled:
+			m.cancelled = true
+			return m, tea.Quit
+		case m.arrange.back:
+			m.arrange = nil
+			m.listModel.Resume()
+		case m.arrange.confirmed:
+			m.selectedFiles = m.arrange.files()
+			return m, tea.Quit
+		}
+		return m, nil
+	}
+
 	// Update the list model
 	updatedModel, cmd := m.listModel.Update(msg)
 	m.listModel = updatedModel.(*ui.Model)
 
-	// If user confirmed (Enter), log selected files
-	if m.listModel.IsQuitting() {
-		m.logSelectedFiles()
+	// If user confirmed (Enter), arrange the picked clips - unless there's only one
+	if m.listModel.IsQuitting() && !m.listModel.IsCancelled() {
+		var picked []*videoFileItem
+		for _, item := range m.listModel.GetSelectedItems() {
+			if videoItem, ok := item.(*videoFileItem); ok {
+				picked = append(picked, videoItem)
+			}
+		}
+		if len(picked) > 1 {
+			m.arrange = newArrangeModel(picked, m.height)
+			return m, nil // Not quitting just yet
+		}
+		m.selectedFiles = make([]string, len(picked))
+		for i, item := range picked {
+			m.selectedFiles[i] = item.filePath
+		}
 	}
 
 	return m, cmd
 }
 
 func (m *megaCombineModel) View() string {
+	if m.arrange != nil {
+		return m.arrange.View()
+	}
 	return m.listModel.View()
 }
 
+// logSelectedFiles shows the files in the order they'll be combined
 func (m *megaCombineModel) logSelectedFiles() {
-	selectedItems := m.listModel.GetSelectedItems()
-	if len(selectedItems) == 0 {
+	if len(m.selectedFiles) == 0 {
 		logger.Info("No files selected")
-		m.selectedFiles = []string{}
 		return
 	}
-
-	// Collect selected files
-	m.selectedFiles = make([]string, 0, len(selectedItems))
-	logger.Info("Selected video files:")
-	for _, item := range selectedItems {
-		if videoItem, ok := item.(*videoFileItem); ok {
-			m.selectedFiles = append(m.selectedFiles, videoItem.filePath)
-			logger.Info("  " + videoItem.filePath)
-		}
+	logger.Info("Combining in this order:")
+	for i, file := range m.selectedFiles {
+		logger.Info(fmt.Sprintf("  %d. %s", i+1, file))
 	}
 }
 
+// videoExtensions are the file extensions mega-combine treats as videos
+var videoExtensions = map[string]bool{
+	".mp4":  true,
+	".avi":  true,
+	".mov":  true,
+	".mkv":  true,
+	".webm": true,
+	".flv":  true,
+	".wmv":  true,
+	".m4v":  true,
+	".mpg":  true,
+	".mpeg": true,
+	".3gp":  true,
+	".ogv":  true,
+}
+
+// isVideoFile reports whether a file name has a video extension
+func isVideoFile(name string) bool {
+	return videoExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
 // getVideoFiles scans the current directory for video files and sorts by modification time
 func getVideoFiles(dir string) ([]videoFileItem, error) {
-	videoExtensions := map[string]bool{
-		".mp4":  true,
-		".avi":  true,
-		".mov":  true,
-		".mkv":  true,
-		".webm": true,
-		".flv":  true,
-		".wmv":  true,
-		".m4v":  true,
-		".mpg":  true,
-		".mpeg": true,
-		".3gp":  true,
-		".ogv":  true,
-	}
-
 	var items []videoFileItem
 
 	entries, err := os.ReadDir(dir)
@@ -151,8 +309,7 @@ func getVideoFiles(dir string) ([]videoFileItem, error) {
 			continue
 		}
 
-		ext := strings.ToLower(filepath.Ext(entry.Name()))
-		if !videoExtensions[ext] {
+		if !isVideoFile(entry.Name()) {
 			continue
 		}
 
@@ -166,30 +323,58 @@ func getVideoFiles(dir string) ([]videoFileItem, error) {
 			title:    entry.Name(),
 			filePath: fullPath,
 			modTime:  info.ModTime(),
+			size:     info.Size(),
 			selected: false,
 		})
 	}
 
-	// Sort by modification time (oldest first)
-	sort.Slice(items, func(i, j int) bool {
-		return items[i].modTime.Before(items[j].modTime)
-	})
+	sortByModTime(items)
 
 	return items, nil
 }
 
+// sortByModTime sorts video files by modification time (oldest first)
+func sortByModTime(items []videoFileItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].modTime.Before(items[j].modTime)
+	})
+}
+
 // RunMegaCombine runs the mega-combine TUI command
 func RunMegaCombine(ctx context.Context) (string, error) {
-	// Check if test mode is enabled
-	testMode := ctx.Value("megaCombineTestMode") == true
+	// `mega-combine presets` just lists them
+	if ctx.Value("megaCombineListPresets") == true {
+		return listPresets()
+	}
+
+	// Files picked on the command line skip the TUI entirely - so scriptable! 🤖
+	selection, err := selectionFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	if selection.active() {
+		files, err := selection.resolve()
+		if err != nil {
+			return "", err
+		}
+		return combineFiles(ctx, files)
+	}
+
+	// No picking without a terminal - Bubble Tea would go looking for /dev/tty
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("no files selected - name them, or use --glob, --from-file or --all (the picker needs a terminal)")
+	}
 
-	model, err := initialMegaCombineModel()
+	probeCtx, stopProbes := context.WithCancel(ctx)
+	defer stopProbes()
+	model, err := initialMegaCombineModel(probeCtx)
 	if err != nil {
 		return "", err
 	}
 
 	p := tea.NewProgram(&model, tea.WithAltScreen())
 	finalModel, err := p.Run()
+	stopProbes() // Nobody's looking at the picker anymore
 	if err != nil {
 		return "", err
 	}
@@ -197,279 +382,188 @@ func RunMegaCombine(ctx context.Context) (string, error) {
 	// Get the final model and extract selected files
 	if m, ok := finalModel.(*megaCombineModel); ok {
 		// Check if user cancelled with Ctrl+C
-		if m.listModel.IsCancelled() {
+		if m.listModel.IsCancelled() || m.cancelled {
 			return "", nil // Exit silently if cancelled
 		}
 
+		// Out of the alt screen now, so the order stays on screen
+		m.logSelectedFiles()
+
 		if len(m.selectedFiles) == 0 {
 			return "No files selected.", nil
 		}
 
-		// Determine encoding mode
-		wayTooBig := ctx.Value("megaCombineWayTooBig") == true
-		slowButSmall := ctx.Value("megaCombineSlowButSmall") == true
-		
-		// Default mode: fast concatenation (no re-encoding)
-		mode := "fast"
-		if wayTooBig {
-			mode = "prores"
-		} else if slowButSmall {
-			mode = "nvenc"
-		}
-
-		// Get output filename from context
-		outputFile := "out.mkv" // Default for fast concat
-		if mode == "prores" {
-			outputFile = "out.mov"
-		} else if mode == "nvenc" {
-			outputFile = "out.mp4"
-		}
-		
-		if outFile := ctx.Value("megaCombineOutput"); outFile != nil {
-			if outFileStr, ok := outFile.(string); ok && outFileStr != "" {
-				outputFile = outFileStr
-				// Add extension if not provided
-				if mode == "prores" {
-					if !strings.HasSuffix(strings.ToLower(outputFile), ".mov") {
-						outputFile = outputFile + ".mov"
-					}
-				} else if mode == "nvenc" {
-					if !strings.HasSuffix(strings.ToLower(outputFile), ".mp4") {
-						outputFile = outputFile + ".mp4"
-					}
-				} else {
-					// Fast mode - keep original extension or use .mkv
-					if !strings.Contains(filepath.Ext(outputFile), ".") {
-						outputFile = outputFile + ".mkv"
-					}
-				}
-			}
-		}
-
-		// In test mode, show the ffmpeg command that would be run
-		if testMode {
-			cmd, err := generateFFmpegCommand(m.selectedFiles, outputFile, mode)
-			if err != nil {
-				return "", err
-			}
-			return cmd, nil
-		}
-
-		// Main mode - actually run the ffmpeg command
-		return runFFmpegCommand(m.selectedFiles, outputFile, mode)
+		return combineFiles(ctx, m.selectedFiles)
 	}
 
 	return "Video file selection completed. Check logs for selected files.", nil
 }
 
-// generateFFmpegCommand creates an ffmpeg command using the selected files
-// mode can be: "fast" (concat demuxer, no re-encoding), "nvenc" (H.265 encoding), or "prores" (ProRes encoding)
-func generateFFmpegCommand(selectedFiles []string, outputFile string, mode string) (string, error) {
-	if len(selectedFiles) == 0 {
-		return "", fmt.Errorf("no files selected")
-	}
-
-	var cmd strings.Builder
-
-	if mode == "fast" {
-		// Fast mode: Use concat demuxer (no re-encoding, just concatenate)
-		cmd.WriteString(" -f concat -safe 0 -i filelist.txt")
-		cmd.WriteString(fmt.Sprintf(" -c copy \"%s\"", outputFile))
-		cmd.WriteString("\n\n# Note: A temporary filelist.txt will be created with:")
-		for i, file := range selectedFiles {
-			absFilePath, err := filepath.Abs(file)
-			if err == nil {
-				cmd.WriteString(fmt.Sprintf("\n#   file '%s'", absFilePath))
-				if i < len(selectedFiles)-1 {
-					cmd.WriteString("")
-				}
-			}
+// combineFiles combines the chosen files with the preset and output from the flags
+func combineFiles(ctx context.Context, selectedFiles []string) (string, error) {
+	// Check if test mode is enabled
+	testMode := ctx.Value("megaCombineTestMode") == true
+
+	// Pick the preset: --preset, or the --waytoobig/--slowbutsmall shortcuts
+	presets, err := loadPresets()
+	if err != nil {
+		return "", err
+	}
+	presetName := "fast" // Default: fast concatenation (no re-encoding)
+	if ctx.Value("megaCombineWayTooBig") == true {
+		presetName = "waytoobig"
+	} else if ctx.Value("megaCombineSlowButSmall") == true {
+		presetName = "slowbutsmall"
+	}
+	if name, ok := ctx.Value("megaCombinePreset").(string); ok {
+		presetName = name
+	}
+	preset, err := findPreset(presets, presetName)
+	if err != nil {
+		return "", err
+	}
+
+	// Copying only works when the clips match, so check them first 🔍
+	var report compatReport
+	normalize := false
+	if preset.Copy {
+		var action string
+		if action, report, err = preflightFast(ctx, selectedFiles, testMode); err != nil {
+			return "", err
 		}
-	} else {
-		// Encoding modes: Use filter_complex with timestamp normalization
-		// Add all input files with -i flag
-		for _, file := range selectedFiles {
-			// Get absolute path for each file to ensure ffmpeg can find them
-			absFilePath, err := filepath.Abs(file)
-			if err != nil {
-				return "", fmt.Errorf("failed to get absolute path for %s: %w", file, err)
+		switch action {
+		case "normalize":
+			normalize = true
+		case "copy":
+		default:
+			// Switched to a re-encoding preset instead
+			if preset, err = findPreset(presets, action); err != nil {
+				return "", err
 			}
-			cmd.WriteString(fmt.Sprintf(" -i \"%s\"", absFilePath))
 		}
+	}
 
-		// Build concat filter complex with timestamp normalization (robust version)
-		// Normalizes timestamps to handle VFR/mismatched starts safely
-		// Format: [0:v]setpts=PTS-STARTPTS[v0];[0:a]asetpts=PTS-STARTPTS[a0];...concat=n=N:v=1:a=1[outv][outa]
-		numFiles := len(selectedFiles)
-		var filterComplex strings.Builder
+	// Re-encoding presets use the best encoder this machine has
+	var encoderNote string
+	if !preset.Copy {
+		if preset, encoderNote, err = chooseEncoder(ctx, preset); err != nil {
+			return "", err
+		}
+	} else if ctx.Value("megaCombineEncoder") != nil {
+		logger.Warn("--encoder only applies when re-encoding", "preset", preset.Name)
+	}
 
-		// Add timestamp normalization for each input
-		for i := 0; i < numFiles; i++ {
-			if i > 0 {
-				filterComplex.WriteString(";")
+	// Get output filename from context
+	outputFile := "out" + preset.Extension
+	if outFileStr, ok := ctx.Value("megaCombineOutput").(string); ok && outFileStr != "" {
+		outputFile = outFileStr
+		// Add extension if not provided
+		if preset.Copy {
+			// Copying - keep original extension or use the preset's
+			if filepath.Ext(outputFile) == "" {
+				outputFile = outputFile + preset.Extension
 			}
-			filterComplex.WriteString(fmt.Sprintf("[%d:v]setpts=PTS-STARTPTS[v%d];[%d:a]asetpts=PTS-STARTPTS[a%d]", i, i, i, i))
+		} else if !strings.HasSuffix(strings.ToLower(outputFile), strings.ToLower(preset.Extension)) {
+			outputFile = outputFile + preset.Extension
 		}
+	}
 
-		// Add concat with normalized streams
-		filterComplex.WriteString(";")
-		for i := 0; i < numFiles; i++ {
-			filterComplex.WriteString(fmt.Sprintf("[v%d][a%d]", i, i))
+	// Odd clips get re-encoded to match the rest, which is still just copied
+	var steps []normalizeStep
+	if normalize {
+		if steps, err = planNormalize(report, outputFile); err != nil {
+			return "", err
 		}
-		filterComplex.WriteString(fmt.Sprintf("concat=n=%d:v=1:a=1[outv][outa]", numFiles))
-
-		// Generate the complete ffmpeg command
-		cmd.WriteString(" \\\n")
-		cmd.WriteString("  -filter_complex \"")
-		cmd.WriteString(filterComplex.String())
-		cmd.WriteString("\" \\\n")
-		cmd.WriteString("  -map \"[outv]\" -map \"[outa]\" \\\n")
-		
-		if mode == "prores" {
-			// ProRes LT - way too big but high quality for DaVinci Resolve
-			cmd.WriteString("  -c:v prores_ks -profile:v 1 -pix_fmt yuv422p10le -threads 0 \\\n")
-			cmd.WriteString("  -c:a pcm_s16le -ar 48000 -ac 2 \\\n")
-		} else if mode == "nvenc" {
-			// NVENC H.265 - GPU accelerated, efficient encoding
-			cmd.WriteString("  -c:v hevc_nvenc -preset p6 -tune hq -rc vbr_hq -cq 22 -b:v 0 -maxrate 0 \\\n")
-			cmd.WriteString("  -pix_fmt p010le -profile:v main10 \\\n")
-			cmd.WriteString("  -c:a aac -b:a 160k -ar 48000 -ac 2 \\\n")
-			cmd.WriteString("  -movflags +faststart \\\n")
-		}
-		
-		cmd.WriteString(fmt.Sprintf("  \"%s\"", outputFile))
 	}
 
-	// Prepend "ffmpeg" to the command
-	fullCmd := "ffmpeg" + cmd.String()
-	return fullCmd, nil
-}
-
-// runFFmpegCommand executes the ffmpeg command with the selected files
-// mode can be: "fast" (concat demuxer, no re-encoding), "nvenc" (H.265 encoding), or "prores" (ProRes encoding)
-func runFFmpegCommand(selectedFiles []string, outputFile string, mode string) (string, error) {
-	if len(selectedFiles) == 0 {
-		return "", fmt.Errorf("no files selected")
+	// Re-encodes build a filter graph, which needs to know which clips have audio,
+	// and the progress bar needs to know how long they are
+	var infos []*media.Info
+	if len(report.clips) > 0 {
+		infos = report.infos()
+	} else if !preset.Copy {
+		infos = probeClips(ctx, selectedFiles)
 	}
+	mixAudio := ctx.Value("megaCombineMixAudio") == true
 
-	// Build the ffmpeg command arguments
-	var args []string
-
-	if mode == "fast" {
-		// Fast mode: Use concat demuxer (no re-encoding, just concatenate)
-		// Create a temporary filelist.txt file
-		filelistPath := filepath.Join(filepath.Dir(outputFile), "filelist.txt")
-		filelist, err := os.Create(filelistPath)
-		if err != nil {
-			return "", fmt.Errorf("failed to create filelist: %w", err)
-		}
-		defer os.Remove(filelistPath) // Clean up after we're done
-		defer filelist.Close()
-
-		// Write file entries to filelist
-		for _, file := range selectedFiles {
-			absFilePath, err := filepath.Abs(file)
-			if err != nil {
-				return "", fmt.Errorf("failed to get absolute path for %s: %w", file, err)
-			}
-			// Escape single quotes in the path for the filelist format
-			escapedPath := strings.ReplaceAll(absFilePath, "'", "'\\''")
-			filelist.WriteString(fmt.Sprintf("file '%s'\n", escapedPath))
+	// Clips get fitted onto one canvas, since concat needs identical frames 🖼️
+	var target canvas
+	if !preset.Copy {
+		if target, err = resolveCanvas(ctx, infos); err != nil {
+			return "", err
 		}
-		filelist.Close()
-
-		// Build concat demuxer command
-		args = append(args, "-f", "concat")
-		args = append(args, "-safe", "0")
-		args = append(args, "-i", filelistPath)
-		args = append(args, "-c", "copy") // Copy streams without re-encoding
-		args = append(args, outputFile)
-	} else {
-		// Encoding modes: Use filter_complex with timestamp normalization
-		// Add all input files with -i flag
-		for _, file := range selectedFiles {
-			// Get absolute path for each file to ensure ffmpeg can find them
-			absFilePath, err := filepath.Abs(file)
-			if err != nil {
-				return "", fmt.Errorf("failed to get absolute path for %s: %w", file, err)
-			}
-			args = append(args, "-i", absFilePath)
+		logger.Info("Combining onto canvas", "canvas", target.String())
+	} else if ctx.Value("megaCombineSize") != nil || ctx.Value("megaCombineFPS") != nil || ctx.Value("megaCombineFit") != nil {
+		logger.Warn("--size, --fps and --fit only apply when re-encoding (--slowbutsmall, --waytoobig or another re-encoding preset)")
+	}
+
+	// ffmpeg has no terminal to ask on before replacing the output, so we decide first
+	overwrite := ctx.Value("megaCombineForce") == true
+	if !testMode && !overwrite {
+		if overwrite, err = confirmOverwrite(outputFile); err != nil {
+			return "", err
 		}
+	}
 
-		// Build concat filter complex with timestamp normalization (robust version)
-		numFiles := len(selectedFiles)
-		var filterComplex strings.Builder
+	// One plan for both the preview and the real run, so they can't disagree
+	plan, err := buildCombinePlan(normalizedFiles(steps, selectedFiles), infos, target, mixAudio, outputFile, preset)
+	if err != nil {
+		return "", err
+	}
+	plan.setOverwrite(overwrite)
 
-		// Add timestamp normalization for each input
-		for i := 0; i < numFiles; i++ {
-			if i > 0 {
-				filterComplex.WriteString(";")
-			}
-			filterComplex.WriteString(fmt.Sprintf("[%d:v]setpts=PTS-STARTPTS[v%d];[%d:a]asetpts=PTS-STARTPTS[a%d]", i, i, i, i))
+	// In test mode, show the compatibility report and the ffmpeg commands that would be run
+	if testMode {
+		shell, _ := ctx.Value("megaCombineShell").(string)
+		if shell == "" {
+			shell = defaultShell()
+		}
+		if shell != "bash" && shell != "pwsh" {
+			return "", fmt.Errorf("invalid --shell %q (want bash or pwsh)", shell)
 		}
 
-		// Add concat with normalized streams
-		filterComplex.WriteString(";")
-		for i := 0; i < numFiles; i++ {
-			filterComplex.WriteString(fmt.Sprintf("[v%d][a%d]", i, i))
+		var preview strings.Builder
+		if len(report.clips) > 0 {
+			preview.WriteString(report.String() + "\n")
 		}
-		filterComplex.WriteString(fmt.Sprintf("concat=n=%d:v=1:a=1[outv][outa]", numFiles))
-
-		// Add filter_complex and other arguments
-		args = append(args, "-filter_complex", filterComplex.String())
-		args = append(args, "-map", "[outv]")
-		args = append(args, "-map", "[outa]")
-
-		if mode == "prores" {
-			// ProRes LT - way too big but high quality for DaVinci Resolve
-			args = append(args, "-c:v", "prores_ks")
-			args = append(args, "-profile:v", "1")
-			args = append(args, "-pix_fmt", "yuv422p10le")
-			args = append(args, "-threads", "0")
-			args = append(args, "-c:a", "pcm_s16le")
-			args = append(args, "-ar", "48000")
-			args = append(args, "-ac", "2")
-		} else if mode == "nvenc" {
-			// NVENC H.265 - GPU accelerated, efficient encoding
-			args = append(args, "-c:v", "hevc_nvenc")
-			args = append(args, "-preset", "p6")        // Quality preset (p1=fastest, p7=slowest/highest quality)
-			args = append(args, "-tune", "hq")          // High quality tuning
-			args = append(args, "-rc", "vbr_hq")        // High quality variable bitrate
-			args = append(args, "-cq", "22")            // Constant quality level (lower = higher quality, 18-28 range)
-			args = append(args, "-b:v", "0")            // Bitrate 0 when using CQ mode
-			args = append(args, "-maxrate", "0")        // Max rate 0 when using CQ mode
-			args = append(args, "-pix_fmt", "p010le")   // 10-bit pixel format
-			args = append(args, "-profile:v", "main10") // H.265 Main 10 profile for 10-bit
-			args = append(args, "-c:a", "aac")
-			args = append(args, "-b:a", "160k") // Audio bitrate
-			args = append(args, "-ar", "48000")
-			args = append(args, "-ac", "2")
-			args = append(args, "-movflags", "+faststart") // Fast start for web streaming
+		for _, step := range steps {
+			preview.WriteString(fmt.Sprintf("# Normalize clip %d\n%s\n", step.index+1, step.plan.render(shell)))
 		}
+		if encoderNote != "" {
+			preview.WriteString("# " + encoderNote + "\n")
+		}
+		preview.WriteString(plan.render(shell))
+		return preview.String(), nil
+	}
+	if encoderNote != "" {
+		fmt.Fprintln(os.Stderr, encoderNote)
+	}
 
-		args = append(args, outputFile)
+	if len(steps) > 0 {
+		defer os.RemoveAll(normalizeDir(outputFile))
+		if err := runNormalize(steps); err != nil {
+			return "", err
+		}
 	}
 
-	// Execute ffmpeg command directly in the terminal
-	// The TUI has already exited and restored the terminal, so this will run in the normal terminal
-	cmd := exec.Command("ffmpeg", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin // Allow interactive input (like 'q' to quit)
+	// Main mode - actually run the ffmpeg command
+	return runCombinePlan(plan, len(selectedFiles), preset)
+}
 
+// runCombinePlan runs the combine plan with a brief message first
+func runCombinePlan(plan ffmpegPlan, fileCount int, preset Preset) (string, error) {
 	// Print a brief message before starting (to stderr so it doesn't interfere with ffmpeg output)
-	if mode == "fast" {
-		fmt.Fprintf(os.Stderr, "Fast concatenating %d video file(s) into %s (no re-encoding)...\n", len(selectedFiles), outputFile)
+	if preset.Copy {
+		fmt.Fprintf(os.Stderr, "Fast concatenating %d video file(s) into %s (no re-encoding)...\n", fileCount, plan.output)
 	} else {
-		fmt.Fprintf(os.Stderr, "Running ffmpeg to combine %d video file(s) into %s...\n", len(selectedFiles), outputFile)
-		fmt.Fprintf(os.Stderr, "Press 'q' during encoding to quit.\n\n")
+		fmt.Fprintf(os.Stderr, "Running ffmpeg to combine %d video file(s) into %s with the %s preset...\n", fileCount, plan.output, preset.Name)
 	}
 
-	// Run the command - this will output directly to the terminal in real-time
-	if err := cmd.Run(); err != nil {
+	// Run the command - with a progress bar in a terminal, progress lines otherwise
+	if err := plan.run(); err != nil {
 		return "", fmt.Errorf("ffmpeg command failed: %w", err)
 	}
 
 	// Success message after completion
-	return fmt.Sprintf("\nVideo files successfully combined into %s\n", outputFile), nil
+	return fmt.Sprintf("\nVideo files successfully combined into %s\n", plan.output), nil
 }
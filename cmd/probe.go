@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"marcli/media"
+)
+
+// probeResult is one file in probe's JSON output - the info, or why we couldn't get it 🔍
+type probeResult struct {
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+	*media.Info
+}
+
+// RunProbe inspects video files with ffprobe and prints what it finds as JSON - so nosy! 🔍
+func RunProbe(ctx context.Context) (string, error) {
+	files, _ := ctx.Value("probeFiles").([]string)
+	if len(files) == 0 {
+		return "", fmt.Errorf("usage: marcli probe <files...>")
+	}
+
+	results := make([]probeResult, 0, len(files))
+	for _, r := range media.ProbeAll(ctx, files) {
+		if errors.Is(r.Err, media.ErrFFprobeNotFound) {
+			return "", r.Err
+		}
+		result := probeResult{Info: r.Info, Path: r.Path}
+		if r.Err != nil {
+			result.Error = r.Err.Error()
+		}
+		results = append(results, result)
+	}
+
+	out, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out) + "\n", nil
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"marcli/api"
+)
+
+// recordingsDir returns where cutiepie-tty keeps its recordings - from config.yml or the cute default! 🎬
+func recordingsDir() string {
+	if config, err := LoadConfig(); err == nil && config.Web.RecordingsDir != "" {
+		return config.Web.RecordingsDir
+	}
+	return api.DefaultRecordingsDir()
+}
+
+// RunRecordings lists, plays and exports web terminal session recordings - lights, camera, action! 🎬
+func RunRecordings(ctx context.Context) (string, error) {
+	action, _ := ctx.Value("recordingsAction").(string)
+	name, _ := ctx.Value("recordingsName").(string)
+	dir := recordingsDir()
+
+	switch action {
+	case "", "list":
+		return listRecordings(dir)
+	case "play":
+		if name == "" {
+			return "", fmt.Errorf("usage: marcli recordings play <name> [--speed 2] [--idle-limit 2]")
+		}
+		speed := 1.0
+		if s, ok := ctx.Value("recordingsSpeed").(float64); ok && s > 0 {
+			speed = s
+		}
+		idleLimit, _ := ctx.Value("recordingsIdleLimit").(float64)
+		return "", playRecording(dir, name, speed, idleLimit)
+	case "export":
+		if name == "" {
+			return "", fmt.Errorf("usage: marcli recordings export <name> [--out file|-] [--format cast|txt]")
+		}
+		out, _ := ctx.Value("recordingsOut").(string)
+		format, _ := ctx.Value("recordingsFormat").(string)
+		return exportRecording(dir, name, out, format)
+	default:
+		return "", fmt.Errorf("unknown recordings action %q (try list, play or export)", action)
+	}
+}
+
+// listRecordings shows every recording with its length and size - so organized! 💅
+func listRecordings(dir string) (string, error) {
+	recordings, err := api.ListRecordings(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to list recordings: %w", err)
+	}
+	if len(recordings) == 0 {
+		return fmt.Sprintf("No recordings yet in %s - start cutiepie-tty with --record! 🎬\n", dir), nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Recordings in %s:\n", dir)
+	for _, rec := range recordings {
+		duration := "?"
+		if cast, err := api.LoadRecording(rec.Path); err == nil {
+			duration = cast.Duration().Round(time.Second).String()
+		}
+		fmt.Fprintf(&b, "  %s  %s  %8s  %s\n", rec.Name, rec.ModTime.Format("2006-01-02 15:04"), duration, formatBytes(rec.Size))
+	}
+	return b.String(), nil
+}
+
+// playRecording replays a recording in this terminal at the given speed.
+// Pauses longer than idleLimit seconds are squashed down to idleLimit (0 keeps them).
+func playRecording(dir, name string, speed, idleLimit float64) error {
+	path, err := api.FindRecording(dir, name)
+	if err != nil {
+		return err
+	}
+	cast, err := api.LoadRecording(path)
+	if err != nil {
+		return err
+	}
+
+	last := 0.0
+	for _, event := range cast.Events {
+		delay := event.Time - last
+		last = event.Time
+		if idleLimit > 0 && delay > idleLimit {
+			delay = idleLimit
+		}
+		if delay > 0 {
+			time.Sleep(time.Duration(delay / speed * float64(time.Second)))
+		}
+
+		// Resizes can't be applied to the viewer's terminal, so only output is replayed
+		if event.Type == "o" {
+			io.WriteString(os.Stdout, event.Data)
+		}
+	}
+
+	fmt.Print("\r\n")
+	return nil
+}
+
+// exportRecording copies a recording out as asciicast (for asciinema/agg) or a plain transcript - ready to share! 💖
+func exportRecording(dir, name, out, format string) (string, error) {
+	path, err := api.FindRecording(dir, name)
+	if err != nil {
+		return "", err
+	}
+
+	if format == "" {
+		format = "cast"
+	}
+	if out == "" {
+		out = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + "." + format
+	}
+
+	var data []byte
+	switch format {
+	case "cast":
+		if data, err = os.ReadFile(path); err != nil {
+			return "", err
+		}
+	case "txt":
+		cast, err := api.LoadRecording(path)
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		for _, event := range cast.Events {
+			if event.Type == "o" {
+				b.WriteString(event.Data)
+			}
+		}
+		data = []byte(b.String())
+	default:
+		return "", fmt.Errorf("unknown export format %q (try cast or txt)", format)
+	}
+
+	// "-" sends it straight to stdout, for pipes and the HTTP API 🤖
+	if out == "-" {
+		return string(data), nil
+	}
+	if err := os.WriteFile(out, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", out, err)
+	}
+	return fmt.Sprintf("Exported %s -> %s ✨\n", name, out), nil
+}
+
+// formatBytes renders a byte count all human-friendly - so readable! ✨
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
+	"marcli/api"
 	"marcli/cmd"
 
 	logger "github.com/charmbracelet/log"
@@ -23,6 +25,26 @@ func initCommands() {
 	commandRegistry["mega-combine"] = cmd.RunMegaCombine
 	commandRegistry["cutiepie"] = cmd.RunCutiepieTUICommand
 	commandRegistry["cutiepie-tty"] = cmd.RunCutiepieTTY
+	commandRegistry["recordings"] = cmd.RunRecordings
+	commandRegistry["probe"] = cmd.RunProbe
+}
+
+// apiCommands are the registered commands cutiepie-tty's HTTP API may run - only the non-interactive ones, since there's nobody to click a picker! 🤖
+// Nothing here gets to pick where files are written on the server. 🔒
+var apiCommands = []api.Command{
+	{Name: "go-echo", Description: `Echo "Golang echo" using native Go code`},
+	{Name: "ps-echo", Description: `Echo "Powershell echo" by launching PowerShell`},
+	{Name: "bash-echo", Description: `Echo "Bash echo" via bash (or sh)`},
+	{Name: "build", Description: "Run go build", Flags: []api.Flag{{Name: "fast"}}},
+	{Name: "version", Description: "Show version and build number"},
+	{Name: "mega-combine", Description: "Combine video files (pick them with args, glob or all)", Flags: []api.Flag{
+		{Name: "test"}, {Name: "waytoobig"}, {Name: "slowbutsmall"}, {Name: "all"}, {Name: "mix-audio"}, {Name: "force"},
+		{Name: "glob", Value: true}, {Name: "from-file", Value: true}, {Name: "since", Value: true}, {Name: "until", Value: true},
+		{Name: "on-mismatch", Value: true}, {Name: "size", Value: true}, {Name: "fps", Value: true}, {Name: "fit", Value: true},
+		{Name: "shell", Value: true}, {Name: "preset", Value: true}, {Name: "encoder", Value: true},
+	}, Args: true},
+	{Name: "probe", Description: "Inspect video files with ffprobe", Args: true},
+	{Name: "recordings", Description: "List or export web terminal recordings (exports come back as the output)", Flags: []api.Flag{{Name: "format", Value: true}}, Args: true, Actions: []string{"list", "export"}, Fixed: []string{"--out", "-"}},
 }
 
 func main() {
@@ -61,8 +83,31 @@ func main() {
 
 		// Handle flags for specific commands
 		if cmdName == "mega-combine" {
+			// Files can come from positional args, --glob, --from-file or stdin ("-")
+			var files, globs []string
 			for i := 1; i < len(args); i++ {
 				switch args[i] {
+				case "--glob":
+					if i+1 < len(args) {
+						globs = append(globs, args[i+1])
+						i++ // Skip the next argument since we consumed it
+					}
+				case "--from-file":
+					if i+1 < len(args) {
+						ctx = context.WithValue(ctx, "megaCombineFromFile", args[i+1])
+						i++ // Skip the next argument since we consumed it
+					}
+				case "--all":
+					ctx = context.WithValue(ctx, "megaCombineAll", true)
+				case "--since", "--until":
+					if i+1 < len(args) {
+						key := "megaCombineSince"
+						if args[i] == "--until" {
+							key = "megaCombineUntil"
+						}
+						ctx = context.WithValue(ctx, key, args[i+1])
+						i++ // Skip the next argument since we consumed it
+					}
 				case "--test":
 					ctx = context.WithValue(ctx, "megaCombineTestMode", true)
 				case "--out":
@@ -70,12 +115,57 @@ func main() {
 						ctx = context.WithValue(ctx, "megaCombineOutput", args[i+1])
 						i++ // Skip the next argument since we consumed it
 					}
+				case "--force":
+					ctx = context.WithValue(ctx, "megaCombineForce", true)
 				case "--waytoobig":
 					ctx = context.WithValue(ctx, "megaCombineWayTooBig", true)
 				case "--slowbutsmall":
 					ctx = context.WithValue(ctx, "megaCombineSlowButSmall", true)
+				case "--size", "--fps", "--fit":
+					if i+1 < len(args) {
+						key := map[string]string{"--size": "megaCombineSize", "--fps": "megaCombineFPS", "--fit": "megaCombineFit"}[args[i]]
+						ctx = context.WithValue(ctx, key, args[i+1])
+						i++ // Skip the next argument since we consumed it
+					}
+				case "--shell":
+					if i+1 < len(args) {
+						ctx = context.WithValue(ctx, "megaCombineShell", args[i+1])
+						i++ // Skip the next argument since we consumed it
+					}
+				case "--preset":
+					if i+1 < len(args) {
+						ctx = context.WithValue(ctx, "megaCombinePreset", args[i+1])
+						i++ // Skip the next argument since we consumed it
+					}
+				case "--encoder":
+					if i+1 < len(args) {
+						ctx = context.WithValue(ctx, "megaCombineEncoder", args[i+1])
+						i++ // Skip the next argument since we consumed it
+					}
+				case "--mix-audio":
+					ctx = context.WithValue(ctx, "megaCombineMixAudio", true)
+				case "--on-mismatch":
+					if i+1 < len(args) {
+						ctx = context.WithValue(ctx, "megaCombineOnMismatch", args[i+1])
+						i++ // Skip the next argument since we consumed it
+					}
+				default:
+					if i == 1 && args[i] == "presets" {
+						ctx = context.WithValue(ctx, "megaCombineListPresets", true)
+					} else if args[i] == "-" || !strings.HasPrefix(args[i], "-") {
+						files = append(files, args[i])
+					} else {
+						// A typo'd flag shouldn't quietly combine something else 🙅
+						logger.Fatal("unknown mega-combine flag", "flag", args[i])
+					}
 				}
 			}
+			if len(files) > 0 {
+				ctx = context.WithValue(ctx, "megaCombineFiles", files)
+			}
+			if len(globs) > 0 {
+				ctx = context.WithValue(ctx, "megaCombineGlobs", globs)
+			}
 		}
 		if cmdName == "build" && len(args) > 1 && args[1] == "--fast" {
 			ctx = context.WithValue(ctx, "buildFastMode", true)
@@ -90,15 +180,67 @@ func main() {
 			}
 		}
 		if cmdName == "cutiepie-tty" {
+			ctx = context.WithValue(ctx, "apiCommands", apiCommands)
+			for i := 1; i < len(args); i++ {
+				switch args[i] {
+				case "--port":
+					if i+1 < len(args) {
+						var port int
+						if _, err := fmt.Sscanf(args[i+1], "%d", &port); err == nil {
+							ctx = context.WithValue(ctx, "port", port)
+						}
+						i++ // Skip the next argument since we consumed it
+					}
+				case "--static-dir":
+					if i+1 < len(args) {
+						ctx = context.WithValue(ctx, "staticDir", args[i+1])
+						i++ // Skip the next argument since we consumed it
+					}
+				case "--record":
+					ctx = context.WithValue(ctx, "record", true)
+				}
+			}
+		}
+		if cmdName == "probe" && len(args) > 1 {
+			ctx = context.WithValue(ctx, "probeFiles", args[1:])
+		}
+		if cmdName == "recordings" {
+			// Positional args: action (list/play/export) and recording name
+			var positional []string
 			for i := 1; i < len(args); i++ {
-				if args[i] == "--port" && i+1 < len(args) {
-					var port int
-					if _, err := fmt.Sscanf(args[i+1], "%d", &port); err == nil {
-						ctx = context.WithValue(ctx, "port", port)
+				switch args[i] {
+				case "--speed", "--idle-limit":
+					if i+1 < len(args) {
+						var val float64
+						if _, err := fmt.Sscanf(args[i+1], "%g", &val); err == nil {
+							key := "recordingsSpeed"
+							if args[i] == "--idle-limit" {
+								key = "recordingsIdleLimit"
+							}
+							ctx = context.WithValue(ctx, key, val)
+						}
+						i++ // Skip the next argument since we consumed it
 					}
-					i++ // Skip the next argument since we consumed it
+				case "--out":
+					if i+1 < len(args) {
+						ctx = context.WithValue(ctx, "recordingsOut", args[i+1])
+						i++ // Skip the next argument since we consumed it
+					}
+				case "--format":
+					if i+1 < len(args) {
+						ctx = context.WithValue(ctx, "recordingsFormat", args[i+1])
+						i++ // Skip the next argument since we consumed it
+					}
+				default:
+					positional = append(positional, args[i])
 				}
 			}
+			if len(positional) > 0 {
+				ctx = context.WithValue(ctx, "recordingsAction", positional[0])
+			}
+			if len(positional) > 1 {
+				ctx = context.WithValue(ctx, "recordingsName", positional[1])
+			}
 		}
 
 		out, err := cmd(ctx)
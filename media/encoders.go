@@ -0,0 +1,117 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// FFmpeg is the ffmpeg binary to run, found on PATH by default
+var FFmpeg = "ffmpeg"
+
+// ErrFFmpegNotFound means ffmpeg isn't installed
+var ErrFFmpegNotFound = errors.New("ffmpeg not found - install ffmpeg to encode videos")
+
+// hwaccels maps hardware encoder suffixes to the hwaccel ffmpeg needs for them
+var hwaccels = map[string]string{
+	"_nvenc":        "cuda",
+	"_qsv":          "qsv",
+	"_vaapi":        "vaapi",
+	"_videotoolbox": "videotoolbox",
+}
+
+// Capabilities is what the local ffmpeg can encode with
+type Capabilities struct {
+	Encoders map[string]bool // Video encoders ffmpeg was built with
+	HWAccels map[string]bool // Hardware acceleration methods ffmpeg was built with
+}
+
+// DetectCapabilities asks ffmpeg for its video encoders (-encoders) and
+// hardware acceleration methods (-hwaccels)
+func DetectCapabilities(ctx context.Context) (*Capabilities, error) {
+	encoders, err := ffmpegOutput(ctx, "-encoders")
+	if err != nil {
+		return nil, err
+	}
+	accels, err := ffmpegOutput(ctx, "-hwaccels")
+	if err != nil {
+		return nil, err
+	}
+	return &Capabilities{
+		Encoders: parseEncoders(encoders),
+		HWAccels: parseHWAccels(accels),
+	}, nil
+}
+
+// CanEncode reports whether an encoder is usable. Hardware encoders are listed
+// whenever ffmpeg was built with them, GPU or not, so they also need their
+// hwaccel and a tiny trial encode to succeed.
+func (c *Capabilities) CanEncode(ctx context.Context, encoder string) bool {
+	if !c.Encoders[encoder] {
+		return false
+	}
+	hardware := false
+	for suffix, accel := range hwaccels {
+		if strings.HasSuffix(encoder, suffix) {
+			if !c.HWAccels[accel] {
+				return false
+			}
+			hardware = true
+		}
+	}
+	if !hardware {
+		return true
+	}
+	cmd := exec.CommandContext(ctx, FFmpeg, "-hide_banner", "-loglevel", "error",
+		"-f", "lavfi", "-i", "color=c=black:s=256x256:d=0.1",
+		"-frames:v", "1", "-c:v", encoder, "-f", "null", "-")
+	return cmd.Run() == nil
+}
+
+// ffmpegOutput runs ffmpeg with one informational flag and returns what it prints
+func ffmpegOutput(ctx context.Context, flag string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, FFmpeg, "-hide_banner", flag).Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, ErrFFmpegNotFound
+	}
+	return out, err
+}
+
+// parseEncoders reads the video encoders from `ffmpeg -encoders`, whose list
+// starts after a "------" line with entries like " V....D libx264  description"
+func parseEncoders(out []byte) map[string]bool {
+	encoders := make(map[string]bool)
+	started := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if !started {
+			started = strings.HasPrefix(fields[0], "---")
+			continue
+		}
+		if len(fields) >= 2 && strings.HasPrefix(fields[0], "V") {
+			encoders[fields[1]] = true
+		}
+	}
+	return encoders
+}
+
+// parseHWAccels reads `ffmpeg -hwaccels`: a heading, then one method per line
+func parseHWAccels(out []byte) map[string]bool {
+	accels := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasSuffix(line, ":") {
+			continue
+		}
+		accels[line] = true
+	}
+	return accels
+}
@@ -0,0 +1,43 @@
+package media
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestParseEncoders(t *testing.T) {
+	out := []byte(`Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ..S... = Slice-level multithreading
+ ...X.. = Codec is experimental
+ ....B. = Supports draw_horiz_band
+ .....D = Supports direct rendering method 1
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D hevc_nvenc           NVIDIA NVENC hevc encoder (codec hevc)
+ VFS... prores_ks            Apple ProRes (iCodec Pro) (codec prores)
+ A....D aac                  AAC (Advanced Audio Coding)
+ S..... srt                  SubRip subtitle
+`)
+	got := slices.Sorted(maps.Keys(parseEncoders(out)))
+	want := []string{"hevc_nvenc", "libx264", "prores_ks"}
+	if !slices.Equal(got, want) {
+		t.Errorf("encoders = %v, want %v (video only, legend skipped)", got, want)
+	}
+}
+
+func TestParseHWAccels(t *testing.T) {
+	out := []byte("Hardware acceleration methods:\ncuda\nvaapi\n\nvideotoolbox\n")
+	got := slices.Sorted(maps.Keys(parseHWAccels(out)))
+	want := []string{"cuda", "vaapi", "videotoolbox"}
+	if !slices.Equal(got, want) {
+		t.Errorf("hwaccels = %v, want %v", got, want)
+	}
+	if len(parseHWAccels(nil)) != 0 {
+		t.Error("no output should mean no hwaccels")
+	}
+}
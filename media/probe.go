@@ -0,0 +1,321 @@
+// Package media inspects video files with ffprobe
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FFprobe is the ffprobe binary to run, found on PATH by default
+var FFprobe = "ffprobe"
+
+// ErrFFprobeNotFound means ffprobe isn't installed (it comes with ffmpeg)
+var ErrFFprobeNotFound = errors.New("ffprobe not found - install ffmpeg to inspect videos")
+
+// Info is everything marcli cares about in a media file
+type Info struct {
+	Path         string        `json:"path"`
+	Size         int64         `json:"size"`
+	Duration     float64       `json:"durationSeconds"`
+	Container    string        `json:"container"`
+	CreationTime time.Time     `json:"creationTime,omitzero"`
+	Video        *VideoStream  `json:"video,omitempty"`
+	Audio        []AudioStream `json:"audio"`
+}
+
+// VideoStream describes the first video stream of a file
+type VideoStream struct {
+	Index       int     `json:"index"`
+	Codec       string  `json:"codec"`
+	Profile     string  `json:"profile,omitempty"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	FrameRate   float64 `json:"frameRate"`
+	PixelFormat string  `json:"pixelFormat"`
+	TimeBase    string  `json:"timeBase,omitempty"`
+	Duration    float64 `json:"durationSeconds,omitempty"`
+	// Rotation is the display rotation in degrees (0, 90, 180 or 270), as
+	// phones record portrait video as rotated landscape
+	Rotation int `json:"rotation"`
+}
+
+// AudioStream describes one audio stream of a file
+type AudioStream struct {
+	Index         int    `json:"index"`
+	Codec         string `json:"codec"`
+	Channels      int    `json:"channels"`
+	ChannelLayout string `json:"channelLayout,omitempty"`
+	SampleRate    int    `json:"sampleRate"`
+	Language      string `json:"language,omitempty"`
+}
+
+// Probe runs ffprobe on a file and boils its JSON down to an Info. Only
+// regular files are probed, and they're passed as file: URLs, so names like
+// "http://..." or "concat:..." can't make ffprobe fetch or read anything else.
+func Probe(ctx context.Context, path string) (*Info, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	cmd := exec.CommandContext(ctx, FFprobe,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		"file:"+path,
+	)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, ErrFFprobeNotFound
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("ffprobe %s: %s", path, msg)
+		}
+		return nil, fmt.Errorf("ffprobe %s: %w", path, err)
+	}
+
+	info, err := parse(out)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe %s: %w", path, err)
+	}
+	info.Path = path
+	if info.Size == 0 {
+		info.Size = stat.Size()
+	}
+	return info, nil
+}
+
+// Concurrency is how many ffprobes ProbeAll runs at once
+var Concurrency = 4
+
+// Result is the outcome of probing one file with ProbeAll
+type Result struct {
+	Path string
+	Info *Info
+	Err  error
+}
+
+// ProbeAll probes files a few at a time and returns the results in the same
+// order as paths
+func ProbeAll(ctx context.Context, paths []string) []Result {
+	results := make([]Result, len(paths))
+	sem := make(chan struct{}, Concurrency)
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			info, err := Probe(ctx, path)
+			results[i] = Result{Path: path, Info: info, Err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// ffprobeOutput is the part of ffprobe's JSON we read
+type ffprobeOutput struct {
+	Streams []struct {
+		Index         int               `json:"index"`
+		CodecType     string            `json:"codec_type"`
+		CodecName     string            `json:"codec_name"`
+		Profile       string            `json:"profile"`
+		Width         int               `json:"width"`
+		Height        int               `json:"height"`
+		RFrameRate    string            `json:"r_frame_rate"`
+		AvgFrameRate  string            `json:"avg_frame_rate"`
+		PixFmt        string            `json:"pix_fmt"`
+		TimeBase      string            `json:"time_base"`
+		Duration      string            `json:"duration"`
+		Channels      int               `json:"channels"`
+		ChannelLayout string            `json:"channel_layout"`
+		SampleRate    string            `json:"sample_rate"`
+		Tags          map[string]string `json:"tags"`
+		SideDataList  []struct {
+			Rotation *float64 `json:"rotation"`
+		} `json:"side_data_list"`
+		Disposition map[string]int `json:"disposition"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		Size       string            `json:"size"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+// parse turns ffprobe's JSON into an Info
+func parse(data []byte) (*Info, error) {
+	var out ffprobeOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("invalid ffprobe output: %w", err)
+	}
+
+	info := &Info{
+		Container: out.Format.FormatName,
+		Audio:     []AudioStream{},
+	}
+	info.Duration, _ = strconv.ParseFloat(out.Format.Duration, 64)
+	info.Size, _ = strconv.ParseInt(out.Format.Size, 10, 64)
+	info.CreationTime = creationTime(out.Format.Tags)
+
+	for _, s := range out.Streams {
+		switch s.CodecType {
+		case "video":
+			// Cover art shows up as a video stream too, so skip it
+			if info.Video != nil || s.Disposition["attached_pic"] == 1 {
+				continue
+			}
+			rate := parseRate(s.AvgFrameRate)
+			if rate == 0 {
+				rate = parseRate(s.RFrameRate)
+			}
+			info.Video = &VideoStream{
+				Index:       s.Index,
+				Codec:       s.CodecName,
+				Profile:     s.Profile,
+				Width:       s.Width,
+				Height:      s.Height,
+				FrameRate:   rate,
+				PixelFormat: s.PixFmt,
+				TimeBase:    s.TimeBase,
+			}
+			info.Video.Duration, _ = strconv.ParseFloat(s.Duration, 64)
+			// Newer ffprobes put rotation in side data (counter-clockwise,
+			// so -90 means 90), older ones in a "rotate" tag
+			for _, sd := range s.SideDataList {
+				if sd.Rotation != nil {
+					info.Video.Rotation = normalizeRotation(int(math.Round(-*sd.Rotation)))
+				}
+			}
+			if r, err := strconv.Atoi(s.Tags["rotate"]); err == nil && info.Video.Rotation == 0 {
+				info.Video.Rotation = normalizeRotation(r)
+			}
+			if info.CreationTime.IsZero() {
+				info.CreationTime = creationTime(s.Tags)
+			}
+		case "audio":
+			rate, _ := strconv.Atoi(s.SampleRate)
+			info.Audio = append(info.Audio, AudioStream{
+				Index:         s.Index,
+				Codec:         s.CodecName,
+				Channels:      s.Channels,
+				ChannelLayout: s.ChannelLayout,
+				SampleRate:    rate,
+				Language:      s.Tags["language"],
+			})
+		}
+	}
+	// Some containers only know how long their streams are
+	if info.Duration == 0 && info.Video != nil {
+		info.Duration = info.Video.Duration
+	}
+	return info, nil
+}
+
+// parseRate turns ffprobe rates like "30000/1001" into frames per second
+func parseRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(rate, 64)
+		return f
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// normalizeRotation maps any rotation onto 0, 90, 180 or 270
+func normalizeRotation(degrees int) int {
+	return ((degrees % 360) + 360) % 360
+}
+
+// creationTime reads the creation_time tag cameras and phones write
+func creationTime(tags map[string]string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, tags["creation_time"])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// HasAudio reports whether the file has any audio stream
+func (i *Info) HasAudio() bool {
+	return len(i.Audio) > 0
+}
+
+// DisplaySize returns the width and height the video is shown at, with
+// rotation applied
+func (v *VideoStream) DisplaySize() (int, int) {
+	if v.Rotation == 90 || v.Rotation == 270 {
+		return v.Height, v.Width
+	}
+	return v.Width, v.Height
+}
+
+// Resolution formats the display size like "1920x1080"
+func (v *VideoStream) Resolution() string {
+	w, h := v.DisplaySize()
+	return fmt.Sprintf("%dx%d", w, h)
+}
+
+// FormatFrameRate formats a frame rate like "29.97" or "30"
+func FormatFrameRate(fps float64) string {
+	return strconv.FormatFloat(math.Round(fps*100)/100, 'f', -1, 64)
+}
+
+// FrameRateExpr formats a frame rate for ffmpeg's fps filter, keeping NTSC
+// rates like 29.97 exact ("30000/1001")
+func FrameRateExpr(fps float64) string {
+	ntsc := math.Round(fps * 1.001)
+	if fps != math.Round(fps) && math.Abs(fps-ntsc/1.001) < 0.001 {
+		return fmt.Sprintf("%d/1001", int(ntsc)*1000)
+	}
+	return FormatFrameRate(fps)
+}
+
+// FormatDuration formats seconds like "1:02:03" or "4:05"
+func FormatDuration(seconds float64) string {
+	total := int(math.Round(seconds))
+	h, m, s := total/3600, total/60%60, total%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// FormatSize formats bytes like "1.2 GB"
+func FormatSize(bytes int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	size := float64(bytes)
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	return fmt.Sprintf("%.1f %s", size, units[i])
+}
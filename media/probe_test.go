@@ -0,0 +1,153 @@
+package media
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`{
+		"streams": [
+			{"index": 0, "codec_type": "video", "codec_name": "mjpeg", "width": 300, "height": 300, "disposition": {"attached_pic": 1}},
+			{"index": 1, "codec_type": "video", "codec_name": "h264", "profile": "High", "width": 1920, "height": 1080,
+			 "r_frame_rate": "60/1", "avg_frame_rate": "30000/1001", "pix_fmt": "yuv420p", "time_base": "1/30000",
+			 "tags": {"creation_time": "2024-05-01T14:30:00.000000Z"}},
+			{"index": 2, "codec_type": "audio", "codec_name": "aac", "channels": 2, "channel_layout": "stereo", "sample_rate": "48000", "tags": {"language": "eng"}},
+			{"index": 3, "codec_type": "audio", "codec_name": "opus", "channels": 1, "sample_rate": "24000"}
+		],
+		"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "12.500000", "size": "1048576"}
+	}`)
+	info, err := parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Duration != 12.5 || info.Size != 1048576 || info.Container != "mov,mp4,m4a,3gp,3g2,mj2" {
+		t.Errorf("format = %v %v %q", info.Duration, info.Size, info.Container)
+	}
+	if got := info.CreationTime.UTC().Format("2006-01-02 15:04"); got != "2024-05-01 14:30" {
+		t.Errorf("creation time = %s", got)
+	}
+	v := info.Video
+	if v == nil || v.Index != 1 {
+		t.Fatalf("video = %+v, want stream 1 (cover art skipped)", v)
+	}
+	if v.Codec != "h264" || v.Profile != "High" || v.Width != 1920 || v.Height != 1080 || v.PixelFormat != "yuv420p" || v.TimeBase != "1/30000" {
+		t.Errorf("video = %+v", v)
+	}
+	if v.FrameRate < 29.97 || v.FrameRate > 29.98 {
+		t.Errorf("frame rate = %v, want avg_frame_rate 29.97", v.FrameRate)
+	}
+	if len(info.Audio) != 2 {
+		t.Fatalf("audio = %+v, want 2 streams", info.Audio)
+	}
+	if a := info.Audio[0]; a.Codec != "aac" || a.Channels != 2 || a.ChannelLayout != "stereo" || a.SampleRate != 48000 || a.Language != "eng" {
+		t.Errorf("audio[0] = %+v", a)
+	}
+}
+
+func TestParseRotation(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   int
+	}{
+		{"none", `{}`, 0},
+		{"side data", `{"side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]}`, 90},
+		{"side data clockwise", `{"side_data_list": [{"rotation": 90}]}`, 270},
+		{"side data upside down", `{"side_data_list": [{"rotation": 180}]}`, 180},
+		{"tag", `{"tags": {"rotate": "90"}}`, 90},
+		{"negative tag", `{"tags": {"rotate": "-90"}}`, 270},
+		{"side data wins over tag", `{"side_data_list": [{"rotation": -90}], "tags": {"rotate": "180"}}`, 90},
+		{"side data without rotation", `{"side_data_list": [{"side_data_type": "CPB properties"}], "tags": {"rotate": "180"}}`, 180},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := `{"index": 0, "codec_type": "video", "codec_name": "hevc", "width": 1920, "height": 1080}`
+			if tt.stream != `{}` {
+				stream = stream[:len(stream)-1] + ", " + tt.stream[1:]
+			}
+			info, err := parse([]byte(`{"streams": [` + stream + `], "format": {}}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Video.Rotation != tt.want {
+				t.Errorf("rotation = %d, want %d", info.Video.Rotation, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisplaySize(t *testing.T) {
+	v := &VideoStream{Width: 1920, Height: 1080, Rotation: 90}
+	if w, h := v.DisplaySize(); w != 1080 || h != 1920 {
+		t.Errorf("display size = %dx%d, want 1080x1920", w, h)
+	}
+	v.Rotation = 180
+	if w, h := v.DisplaySize(); w != 1920 || h != 1080 {
+		t.Errorf("display size = %dx%d, want 1920x1080", w, h)
+	}
+}
+
+func TestParseStreamDuration(t *testing.T) {
+	// Some containers only have a duration on the stream
+	info, err := parse([]byte(`{"streams": [{"codec_type": "video", "codec_name": "vp9", "duration": "4.250"}], "format": {"format_name": "webm"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 4.25 || info.Video.Duration != 4.25 {
+		t.Errorf("duration = %v (video %v), want 4.25", info.Duration, info.Video.Duration)
+	}
+	if info.HasAudio() {
+		t.Errorf("audio = %+v, want none", info.Audio)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := parse([]byte("not json")); err == nil {
+		t.Error("parse accepted invalid JSON")
+	}
+}
+
+func TestProbeOnlyRegularFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffprobe is a shell script")
+	}
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	fake := filepath.Join(dir, "ffprobe")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + args + "\necho '{\"format\": {\"duration\": \"1.5\"}}'\n"
+	if err := os.WriteFile(fake, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { FFprobe = old }(FFprobe)
+	FFprobe = fake
+
+	clip := filepath.Join(dir, "clip.mp4")
+	os.WriteFile(clip, []byte("not really a video"), 0644)
+	info, err := Probe(context.Background(), clip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Path != clip || info.Duration != 1.5 || info.Size != 18 {
+		t.Errorf("info = %+v", info)
+	}
+	out, _ := os.ReadFile(args)
+	if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); lines[len(lines)-1] != "file:"+clip {
+		t.Errorf("ffprobe got %q, want the clip as a file: URL", lines[len(lines)-1])
+	}
+
+	for _, path := range []string{"http://example.com/clip.mp4", "concat:" + clip + "|" + clip, dir, filepath.Join(dir, "missing.mp4")} {
+		os.Remove(args)
+		if _, err := Probe(context.Background(), path); err == nil {
+			t.Errorf("Probe(%q) worked, want an error", path)
+		}
+		if _, err := os.Stat(args); err == nil {
+			t.Errorf("Probe(%q) ran ffprobe", path)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package media
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Progress is how far along a running ffmpeg is, worked out from its
+// -progress output and the durations of the clips going in
+type Progress struct {
+	Percent float64 `json:"percent"`                // 0-100, or 0 while the total duration is unknown
+	Seconds float64 `json:"outSeconds"`             // How much of the output is written so far
+	Total   float64 `json:"totalSeconds,omitempty"` // The output's expected duration
+	Speed   float64 `json:"speed,omitempty"`        // Times realtime
+	Size    int64   `json:"sizeBytes"`              // Output file size so far
+	ETA     float64 `json:"etaSeconds,omitempty"`   // Time left at the current speed
+	Clip    int     `json:"clip,omitempty"`         // The clip being encoded, counting from 1
+	Clips   int     `json:"clips,omitempty"`
+	Done    bool    `json:"done,omitempty"`
+}
+
+// ReadProgress reads the key=value blocks ffmpeg writes with -progress and
+// calls fn after each one. clips are the input durations in seconds, in
+// concat order, used for the percentage and the current clip.
+func ReadProgress(r io.Reader, clips []float64, fn func(Progress)) error {
+	p := Progress{Clips: len(clips)}
+	for _, d := range clips {
+		p.Total += d
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us":
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				p.Seconds = float64(us) / 1e6
+			}
+		case "total_size":
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil {
+				p.Size = size
+			}
+		case "speed":
+			// "1.23x", or "N/A" before the first frame
+			if speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64); err == nil {
+				p.Speed = speed
+			}
+		case "progress":
+			// Last key of every block: "continue", or "end" when ffmpeg is done
+			p.Done = value == "end"
+			p.update(clips)
+			fn(p)
+		}
+	}
+	return scanner.Err()
+}
+
+// update works out the percentage, ETA and current clip
+func (p *Progress) update(clips []float64) {
+	p.Percent, p.ETA = 0, 0
+	if p.Total > 0 {
+		p.Percent = min(100, p.Seconds/p.Total*100)
+		if p.Speed > 0 {
+			p.ETA = max(0, p.Total-p.Seconds) / p.Speed
+		}
+	}
+
+	p.Clip = 0
+	end := 0.0
+	for i, d := range clips {
+		end += d
+		p.Clip = i + 1
+		if p.Seconds < end {
+			break
+		}
+	}
+
+	if p.Done {
+		p.Percent, p.ETA, p.Clip = 100, 0, len(clips)
+	}
+}
+
+// String formats progress as one line, like
+// "progress 42.3% clip=2/5 time=83.2s/180.0s speed=1.80x size=126214144 eta=54s",
+// for logs and the job queue. ParseProgressLine reads it back.
+func (p Progress) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "progress %.1f%%", p.Percent)
+	if p.Clips > 0 {
+		fmt.Fprintf(&b, " clip=%d/%d", p.Clip, p.Clips)
+	}
+	fmt.Fprintf(&b, " time=%.1fs/%.1fs speed=%.2fx size=%d eta=%.0fs", p.Seconds, p.Total, p.Speed, p.Size, p.ETA)
+	if p.Done {
+		b.WriteString(" done")
+	}
+	return b.String()
+}
+
+// ParseProgressLine reads a line written by Progress.String
+func ParseProgressLine(line string) (Progress, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "progress" || !strings.HasSuffix(fields[1], "%") {
+		return Progress{}, false
+	}
+	var p Progress
+	var err error
+	if p.Percent, err = strconv.ParseFloat(strings.TrimSuffix(fields[1], "%"), 64); err != nil {
+		return Progress{}, false
+	}
+	for _, field := range fields[2:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "clip":
+			fmt.Sscanf(value, "%d/%d", &p.Clip, &p.Clips)
+		case "time":
+			fmt.Sscanf(value, "%fs/%fs", &p.Seconds, &p.Total)
+		case "speed":
+			p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "size":
+			p.Size, _ = strconv.ParseInt(value, 10, 64)
+		case "eta":
+			p.ETA, _ = strconv.ParseFloat(strings.TrimSuffix(value, "s"), 64)
+		case "done":
+			p.Done = true
+		}
+	}
+	return p, true
+}
@@ -0,0 +1,97 @@
+package media
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadProgress(t *testing.T) {
+	// Two blocks the way ffmpeg writes them with -progress, then the last one
+	input := strings.Join([]string{
+		"frame=120",
+		"fps=30.00",
+		"total_size=N/A",
+		"out_time_us=N/A",
+		"speed=N/A",
+		"progress=continue",
+		"frame=900",
+		"total_size=1048576",
+		"out_time_us=15000000",
+		"out_time=00:00:15.000000",
+		"speed=2.5x",
+		"progress=continue",
+		"total_size=2097152",
+		"out_time_us=30000000",
+		"speed= 3x",
+		"progress=end",
+	}, "\n")
+
+	var got []Progress
+	if err := ReadProgress(strings.NewReader(input), []float64{10, 20}, func(p Progress) { got = append(got, p) }); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Progress{
+		{Total: 30, Clip: 1, Clips: 2},
+		{Percent: 50, Seconds: 15, Total: 30, Speed: 2.5, Size: 1048576, ETA: 6, Clip: 2, Clips: 2},
+		{Percent: 100, Seconds: 30, Total: 30, Speed: 3, Size: 2097152, Clip: 2, Clips: 2, Done: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d updates, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("update %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReadProgressUnknownDuration(t *testing.T) {
+	var got Progress
+	ReadProgress(strings.NewReader("out_time_us=5000000\nspeed=1x\nprogress=continue\n"), nil, func(p Progress) { got = p })
+	if got.Percent != 0 || got.ETA != 0 || got.Seconds != 5 || got.Clip != 0 {
+		t.Errorf("progress = %+v, want 5s with no percentage", got)
+	}
+}
+
+func TestProgressLineRoundTrip(t *testing.T) {
+	tests := []struct {
+		progress Progress
+		line     string
+	}{
+		{
+			Progress{Percent: 42.3, Seconds: 83.2, Total: 180, Speed: 1.8, Size: 126214144, ETA: 54, Clip: 2, Clips: 5},
+			"progress 42.3% clip=2/5 time=83.2s/180.0s speed=1.80x size=126214144 eta=54s",
+		},
+		{
+			Progress{Seconds: 12.5},
+			"progress 0.0% time=12.5s/0.0s speed=0.00x size=0 eta=0s",
+		},
+		{
+			Progress{Percent: 100, Seconds: 30, Total: 30, Speed: 3, Size: 2097152, Clip: 2, Clips: 2, Done: true},
+			"progress 100.0% clip=2/2 time=30.0s/30.0s speed=3.00x size=2097152 eta=0s done",
+		},
+	}
+	for _, tt := range tests {
+		line := tt.progress.String()
+		if line != tt.line {
+			t.Errorf("String() = %q, want %q", line, tt.line)
+		}
+		parsed, ok := ParseProgressLine(line)
+		if !ok {
+			t.Errorf("ParseProgressLine(%q) failed", line)
+			continue
+		}
+		if parsed != tt.progress {
+			t.Errorf("ParseProgressLine(%q) = %+v, want %+v", line, parsed, tt.progress)
+		}
+	}
+}
+
+func TestParseProgressLineRejects(t *testing.T) {
+	for _, line := range []string{"", "progress", "progress soon", "Building marcli...", "[1/3] progress 50%"} {
+		if p, ok := ParseProgressLine(line); ok {
+			t.Errorf("ParseProgressLine(%q) = %+v, want no match", line, p)
+		}
+	}
+}
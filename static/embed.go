@@ -0,0 +1,9 @@
+// Package static holds the web terminal's assets, compiled right into the binary so cutiepie-tty works from anywhere! 🌐
+package static
+
+import "embed"
+
+// FS contains index.html plus the JS and CSS it loads - so portable! ✨
+//
+//go:embed *.html *.js *.css
+var FS embed.FS
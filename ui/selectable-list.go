@@ -3,6 +3,7 @@ package ui
 import (
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/list"
@@ -17,6 +18,7 @@ var (
 	itemStyle         = lipgloss.NewStyle().PaddingLeft(4)
 	selectedItemStyle = lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("170"))
 	paginationStyle   = list.DefaultStyles().PaginationStyle.PaddingLeft(4)
+	statusStyle       = lipgloss.NewStyle().PaddingLeft(4).Foreground(lipgloss.Color("170"))
 	helpStyle         = list.DefaultStyles().HelpStyle.PaddingLeft(4).PaddingBottom(1)
 	borderStyle       = lipgloss.NewStyle().
 				Border(lipgloss.RoundedBorder()).
@@ -71,6 +73,7 @@ type Model struct {
 	selected  map[int]struct{}
 	quitting  bool
 	cancelled bool // True if user pressed Ctrl+C to quit
+	status    func(selected []SelectableItem) string
 }
 
 // Config holds configuration for creating a selectable list
@@ -80,6 +83,8 @@ type Config struct {
 	Width      int
 	Height     int
 	HelpText   string
+	// Status, if set, renders a line under the list from the selected items
+	Status func(selected []SelectableItem) string
 }
 
 // New creates a new selectable list model
@@ -118,6 +123,7 @@ func New(cfg Config) *Model {
 		list:     l,
 		items:    cfg.Items,
 		selected: selected,
+		status:   cfg.Status,
 	}
 }
 
@@ -179,15 +185,19 @@ func (m *Model) View() string {
 		return ""
 	}
 	listView := m.list.View()
+	if m.status != nil {
+		listView += "\n" + statusStyle.Render(m.status(m.GetSelectedItems()))
+	}
 	return "\n" + borderStyle.Render(listView)
 }
 
-// GetSelectedIndices returns the indices of all selected items
+// GetSelectedIndices returns the indices of all selected items, in list order
 func (m *Model) GetSelectedIndices() []int {
 	indices := make([]int, 0, len(m.selected))
 	for idx := range m.selected {
 		indices = append(indices, idx)
 	}
+	sort.Ints(indices)
 	return indices
 }
 
@@ -208,6 +218,12 @@ func (m *Model) IsQuitting() bool {
 	return m.quitting
 }
 
+// Resume shows the list again after Enter, e.g. when the user backs out of a
+// later step to change their selection
+func (m *Model) Resume() {
+	m.quitting = false
+}
+
 // IsCancelled returns whether the user cancelled with Ctrl+C
 func (m *Model) IsCancelled() bool {
 	return m.cancelled